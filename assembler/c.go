@@ -1,96 +1,517 @@
 package assembler
 
-import (
-	"strings"
-	"text/template"
-)
+import "text/template"
 
 func C(root MatcherGenerator) (string, error) {
 	tmpl, err := template.New("program").Parse(`#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
 #include <stdbool.h>
 
-/* This implementation is hideous because I'm shoehorning C into the structures I
- * developed for Go source, which rely on closures for simple output code. */
+/* C has no closures, so the expression is built as a tree of nodes which
+ * match() walks, keeping what remains to be matched in a chain of
+ * continuations on the stack. The input is decoded from UTF-8 as it is
+ * matched, so that characters are code points as in the other backends. */
 
-/* matches and increments the ptr */
-bool matchc(char c, char **input) {
-	if (*input[0] == '\0' || *input[0] != c) {
+typedef enum { CHAR, CLASS, DOT, BEGIN, END, OR, CONCAT, CLOSURE, OPTIONAL, GROUP, EMPTY, FAIL, AUTOMATON, LONGEST } nodekind;
+
+/* a state of an automaton accepts if accepting is set, and moves on each of
+ * its n edges to the state numbered to on any character within the ranges */
+typedef struct {
+	const int *ranges;
+	int nranges;
+	int to;
+} edge;
+
+typedef struct {
+	bool accepting;
+	const edge *edges;
+	int nedges;
+} autstate;
+
+typedef struct node {
+	nodekind kind;
+	int c;
+	bool negated, nl, lazy, line;
+	const int *ranges;
+	int nranges;
+	struct node *a, *b;
+	int min, max;
+	int group;
+	const autstate *states;
+} node;
+
+node *newnode(nodekind kind, node *a, node *b) {
+	node *n = calloc(1, sizeof(node));
+	n->kind = kind;
+	n->a = a;
+	n->b = b;
+	return n;
+}
+
+node *chr(int c) {
+	node *n = newnode(CHAR, NULL, NULL);
+	n->c = c;
+	return n;
+}
+
+/* matches any character within (or, if negated, outside) the n given ranges,
+ * listed in order as pairs of inclusive bounds; RANGES(table) gives both for
+ * a table of them */
+#define RANGES(table) table, (int)(sizeof table / sizeof table[0] / 2)
+node *cls(bool negated, const int *ranges, int n) {
+	node *c = newnode(CLASS, NULL, NULL);
+	c->negated = negated;
+	c->ranges = ranges;
+	c->nranges = n;
+	return c;
+}
+
+/* matches any character, except newline unless nl is set */
+node *dot(bool nl) {
+	node *n = newnode(DOT, NULL, NULL);
+	n->nl = nl;
+	return n;
+}
+
+/* match the empty string at the beginning and end of the input or, if line
+ * is set, of any line */
+node *begin(bool line) {
+	node *n = newnode(BEGIN, NULL, NULL);
+	n->line = line;
+	return n;
+}
+
+node *end(bool line) {
+	node *n = newnode(END, NULL, NULL);
+	n->line = line;
+	return n;
+}
+
+/* match the empty string, and nothing */
+node *empty(void) {
+	return newnode(EMPTY, NULL, NULL);
+}
+
+node *fail(void) {
+	return newnode(FAIL, NULL, NULL);
+}
+
+/* matches the strings that a deterministic automaton accepts from the state
+ * numbered start, preferring the longest */
+node *automaton(int start, const autstate *states) {
+	node *n = newnode(AUTOMATON, NULL, NULL);
+	n->c = start;
+	n->states = states;
+	return n;
+}
+
+node *or(node *a, node *b) {
+	return newnode(OR, a, b);
+}
+
+node *concat(node *a, node *b) {
+	return newnode(CONCAT, a, b);
+}
+
+/* matches at least min and (unless it is negative) at most max occurrences
+ * of a, preferring as many as possible or, if lazy, as few */
+node *closure(node *a, int min, int max, bool lazy) {
+	node *n = newnode(CLOSURE, a, NULL);
+	n->min = min;
+	n->max = max;
+	n->lazy = lazy;
+	return n;
+}
+
+/* matches a or nothing, preferring a or, if lazy, nothing */
+node *optional(node *a, bool lazy) {
+	node *n = newnode(OPTIONAL, a, NULL);
+	n->lazy = lazy;
+	return n;
+}
+
+/* matches the longest of the strings a matches and, among those, the first
+ * it matches */
+node *longest(node *a) {
+	return newnode(LONGEST, a, NULL);
+}
+
+/* decode returns the character UTF-8 encoded at s, setting *width to the
+ * number of bytes it takes. As in Go, an invalid encoding decodes as U+FFFD
+ * one byte wide. */
+int decode(const char *s, int *width) {
+	static const int least[] = { 0, 0, 0x80, 0x800, 0x10000 };
+	const unsigned char *u = (const unsigned char *)s;
+	int n, r;
+	*width = 1;
+	if (u[0] < 0x80) {
+		return u[0];
+	} else if ((u[0] & 0xe0) == 0xc0) {
+		n = 2, r = u[0] & 0x1f;
+	} else if ((u[0] & 0xf0) == 0xe0) {
+		n = 3, r = u[0] & 0x0f;
+	} else if ((u[0] & 0xf8) == 0xf0) {
+		n = 4, r = u[0] & 0x07;
+	} else {
+		return 0xfffd;
+	}
+	for (int i = 1; i < n; i++) {
+		if ((u[i] & 0xc0) != 0x80) {
+			return 0xfffd;
+		}
+		r = r << 6 | (u[i] & 0x3f);
+	}
+	if (r < least[n] || r > 0x10ffff || (0xd800 <= r && r <= 0xdfff)) {
+		return 0xfffd;
+	}
+	*width = n;
+	return r;
+}
+
+/* advance returns s moved past the character at it */
+char *advance(char *s) {
+	int width;
+	decode(s, &width);
+	return s + width;
+}
+
+/* records what a matches as submatch n */
+node *group(int n, node *a) {
+	node *g = newnode(GROUP, a, NULL);
+	g->group = n;
+	return g;
+}
+
+/* A cont is what remains to be matched: either the node n, or (if again is
+ * set) further occurrences of the closure n after count of them, the last
+ * having begun at start, or (if close is set) the end of the group n begun at
+ * start, or (if longest is set) nothing, but the match of the LONGEST node n
+ * is kept in longest if it is the longest so far. */
+typedef struct cont {
+	node *n;
+	bool again, close;
+	int count;
+	char *start;
+	struct cont *next;
+	struct longestmatch *longest;
+} cont;
+
+/* the input, and the end of the match when match() succeeds */
+char *text, *matchend;
+
+/* fields names the match and then each of its submatches in the output */
+const char *fields[] = { {{- range $n, $field := .Fields }}{{ if $n }}, {{ end }}{{ printf "%q" $field }}{{ end -}} };
+#define NFIELDS (sizeof fields / sizeof fields[0])
+
+/* caps holds the bounds of the match being tried and then of each of its
+ * submatches, NULL where a group has not matched */
+char *caps[2 * NFIELDS];
+
+/* a longestmatch is the longest match found by a LONGEST node: where it
+ * ends, NULL until there is one, and the submatches with it */
+typedef struct longestmatch {
+	char *end;
+	char *caps[2 * NFIELDS];
+} longestmatch;
+
+bool match(node *n, char *input, cont *k);
+bool repeat(node *n, char *input, int count, cont *k);
+
+/* proceed matches the continuation k */
+bool proceed(char *input, cont *k) {
+	if (k == NULL) {
+		matchend = input;
+		return true;
+	}
+	if (k->longest != NULL) {
+		if (k->longest->end == NULL || input > k->longest->end) {
+			k->longest->end = input;
+			memcpy(k->longest->caps, caps, sizeof caps);
+		}
 		return false;
 	}
-	(*input)++;
-	return true;
+	if (k->close) {
+		int g = k->n->group;
+		char *lo = caps[2 * g], *hi = caps[2 * g + 1];
+		caps[2 * g] = k->start;
+		caps[2 * g + 1] = input;
+		if (proceed(input, k->next)) {
+			return true;
+		}
+		caps[2 * g] = lo;
+		caps[2 * g + 1] = hi;
+		return false;
+	}
+	if (k->again) {
+		/* an occurrence that matches nothing can't lead anywhere new: as in
+		 * Go's regexp, an unbounded closure ends at the first such occurrence
+		 * after the mandatory ones (or the last of them), and fails at later
+		 * ones */
+		int count = k->count - 1, last = k->n->min - 1;
+		if (input == k->start && k->n->max < 0 && count >= last) {
+			return (count == last || count == 0) && proceed(input, k->next);
+		}
+		return repeat(k->n, input, k->count, k->next);
+	}
+	return match(k->n, input, k->next);
 }
 
-bool match(char **input) {
-	bool matched = true;
+/* repeat matches the occurrences that follow the first count */
+bool repeat(node *n, char *input, int count, cont *k) {
+	cont again = { n, true, false, count + 1, input, k };
+	if (n->lazy && count >= n->min && proceed(input, k)) {
+		return true;
+	}
+	if (count != n->max && match(n->a, input, &again)) {
+		return true;
+	}
+	return !n->lazy && count >= n->min && proceed(input, k);
+}
 
-	{{ . }}
+/* inranges reports whether the character r lies within the n ranges */
+bool inranges(const int *ranges, int n, int r) {
+	/* binary search for the range holding r */
+	int lo = 0, hi = n;
+	while (lo < hi) {
+		int m = (lo + hi) / 2;
+		if (r < ranges[2 * m]) {
+			hi = m;
+		} else if (r > ranges[2 * m + 1]) {
+			lo = m + 1;
+		} else {
+			return true;
+		}
+	}
+	return false;
+}
 
-	return matched;
+/* single reports whether the character r matches the CHAR, CLASS or DOT
+ * node n */
+bool single(node *n, int r) {
+	switch (n->kind) {
+	case CHAR:
+		return r == n->c;
+	case CLASS:
+		return inranges(n->ranges, n->nranges, r) != n->negated;
+	default:
+		return n->nl || r != '\n';
+	}
 }
 
-int main(int argc, char **argv) {
-   if (argc != 2) {
-	   printf("must supply input string\n");
-	   return 1;
-   }
-   char* input = argv[1];
-   while (input[0] != '\0') {
-	   char* start = input;
-	   if (match(&input)) {
-		   printf("%.*s\n", (int)(input-start), start);
-		   continue;
-	   } 
-	   input++;
-   }
-}
-	`)
-	if err != nil {
-		return "", err
+/* run matches the automaton of the AUTOMATON node n from its state s, trying
+ * the longer matches first */
+bool run(node *n, int s, char *input, cont *k) {
+	const autstate *st = &n->states[s];
+	if (input[0] != '\0') {
+		int width, r = decode(input, &width);
+		for (int i = 0; i < st->nedges; i++) {
+			if (inranges(st->edges[i].ranges, st->edges[i].nranges, r)) {
+				if (run(n, st->edges[i].to, input + width, k)) {
+					return true;
+				}
+				break;
+			}
+		}
 	}
+	return st->accepting && proceed(input, k);
+}
 
-	gens, err := codeGens(`if (matched) {
-if (!matchc('{{ . }}', input)) {
-			matched = false;
-		}
-	}
-`,
-		`// BEGIN OR
-	if (matched) {
-		{{ .MatcherFuncA }}
-		if (!matched) {
-			matched = true;
-			{{ .MatcherFuncB }}
-		}
-	} // END OR`,
-		`// BEGIN CONCAT
-	if (matched) {
-		{{ .MatcherFuncA }}
-		{{ .MatcherFuncB }}
-	} // END CONCAT `,
-		`// BEGIN CLOSURE
-	if (matched) {
-		for (int i = 0; i < 0; i++) {
-			{{ .MatcherFuncA }}
-		}
-		while (matched) {
-			{{ .MatcherFuncA }}
-		}
-		matched = true;
-	} // END CLOSURE`,
-	)
-	if err != nil {
-		return "", err
+/* match tries every way of matching n at input, in order of preference,
+ * until the continuation k also matches */
+bool match(node *n, char *input, cont *k) {
+	switch (n->kind) {
+	case CHAR:
+	case CLASS:
+	case DOT: {
+		int width, r = decode(input, &width);
+		return input[0] != '\0' && single(n, r) && proceed(input + width, k);
+	}
+	case BEGIN:
+		return (input == text || (n->line && input[-1] == '\n')) && proceed(input, k);
+	case END:
+		return (input[0] == '\0' || (n->line && input[0] == '\n')) && proceed(input, k);
+	case OR:
+		return match(n->a, input, k) || match(n->b, input, k);
+	case CONCAT: {
+		cont rest = { n->b, false, false, 0, NULL, k };
+		return match(n->a, input, &rest);
+	}
+	case GROUP: {
+		cont close = { n, false, true, 0, input, k };
+		return match(n->a, input, &close);
+	}
+	case CLOSURE:
+		return repeat(n, input, 0, k);
+	case OPTIONAL:
+		if (n->lazy) {
+			return proceed(input, k) || match(n->a, input, k);
+		}
+		return match(n->a, input, k) || proceed(input, k);
+	case EMPTY:
+		return proceed(input, k);
+	case FAIL:
+		return false;
+	case AUTOMATON:
+		return run(n, n->c, input, k);
+	case LONGEST: {
+		/* every match is tried, keeping the submatches of the longest */
+		longestmatch longest = { NULL };
+		cont keep = { n, false, false, 0, NULL, NULL, &longest };
+		char *restore[2 * NFIELDS];
+		match(n->a, input, &keep);
+		if (longest.end == NULL) {
+			return false;
+		}
+		memcpy(restore, caps, sizeof caps);
+		memcpy(caps, longest.caps, sizeof caps);
+		if (proceed(longest.end, k)) {
+			return true;
+		}
+		memcpy(caps, restore, sizeof caps);
+		return false;
 	}
+	}
+	return false;
+}
+
+/* find reports whether there is a match beginning at start, setting caps */
+bool find(node *expr, char *start) {
+	memset(caps, 0, sizeof caps);
+	if (!match(expr, start, NULL)) {
+		return false;
+	}
+	caps[0] = start;
+	caps[1] = matchend;
+	return true;
+}
+
+/* printstr prints the text from s to end as a JSON string. Only quotes,
+ * backslashes and control characters are escaped, by the short escapes where
+ * JSON has them, and an invalid encoding is printed as U+FFFD, as it is
+ * decoded */
+void printstr(const char *s, const char *end) {
+	putchar('"');
+	while (s < end) {
+		int width, r = decode(s, &width);
+		switch (r) {
+		case '"': case '\\':
+			printf("\\%c", r);
+			break;
+		case '\b':
+			printf("\\b");
+			break;
+		case '\f':
+			printf("\\f");
+			break;
+		case '\n':
+			printf("\\n");
+			break;
+		case '\r':
+			printf("\\r");
+			break;
+		case '\t':
+			printf("\\t");
+			break;
+		default:
+			if (r < 0x20) {
+				printf("\\u%04x", r);
+			} else if (r == 0xfffd) {
+				printf("\xef\xbf\xbd");
+			} else {
+				fwrite(s, 1, width, stdout);
+			}
+		}
+		s += width;
+	}
+	putchar('"');
+}
+
+/* submatches prints the match and its submatches as a JSON object keyed by
+ * fields, with null for groups that did not match */
+void submatches(void) {
+	printf("{");
+	for (size_t n = 0; n < NFIELDS; n++) {
+		if (n > 0) {
+			printf(", ");
+		}
+		printstr(fields[n], fields[n] + strlen(fields[n]));
+		printf(": ");
+		if (caps[2 * n] == NULL) {
+			printf("null");
+		} else {
+			printstr(caps[2 * n], caps[2 * n + 1]);
+		}
+	}
+	printf("}\n");
+}
 
-	data, err := root.Generate(gens)
+{{ if .Tables -}}
+/* the tables list the ranges of the classes and automata */
+{{ range .Tables }}{{ . }}
+{{ end }}
+{{ end -}}
+int main(int argc, char **argv) {
+	if (argc != 2) {
+		printf("must supply input string\n");
+		return 1;
+	}
+	node *expr = {{ .Matcher }};
+
+	char *input = text = argv[1], *end = input + strlen(input);
+	char *prevend = NULL;
+	/* an empty match right after a previous match is ignored */
+	for (char *pos = input; pos <= end;) {
+		char *start = pos;
+		while (start <= end && !find(expr, start)) {
+			start = advance(start);
+		}
+		if (start > end) {
+			break;
+		}
+		bool accept = matchend != pos || start != prevend;
+		pos = matchend == pos ? advance(pos) : matchend;
+		prevend = matchend;
+		if (!accept) {
+			continue;
+		}
+		/* matches are printed as JSON strings, with the submatches of
+		 * expressions with groups in an object */
+		if (NFIELDS > 1) {
+			submatches();
+		} else {
+			printstr(start, prevend);
+			putchar('\n');
+		}
+	}
+	return 0;
+}
+`)
 	if err != nil {
 		return "", err
 	}
 
-	var buf strings.Builder
-	if err := tmpl.Execute(&buf, data); err != nil {
+	data, err := generate(root, templates{
+		Quote:    quoteC,
+		Rune:     "chr({{ quote . }})",
+		Or:       "or({{ .MatcherFuncA }}, {{ .MatcherFuncB }})",
+		Concat:   "concat({{ .MatcherFuncA }}, {{ .MatcherFuncB }})",
+		Closure:  "closure({{ .MatcherFuncA }}, {{ .Min }}, {{ .Max }}, {{ .Lazy }})",
+		Any:      "dot({{ .NL }})",
+		Begin:    "begin({{ .Line }})",
+		End:      "end({{ .Line }})",
+		Optional: "optional({{ .MatcherFuncA }}, {{ .Lazy }})",
+		Group:    "group({{ .N }}, {{ .MatcherFuncA }})",
+		Class:    "cls({{ .Negated }}, RANGES(table{{ .Table }}))",
+		Empty:    "empty()",
+		Fail:     "fail()",
+		Automaton: "automaton({{ .Start }}, (autstate[]){ {{- range $n, $s := .States }}{{ if $n }}, {{ end }}" +
+			"{ {{- .Accepting }}, {{ if .Edges }}(edge[]){ {{- range $i, $e := .Edges }}{{ if $i }}, {{ end }}{RANGES(table{{ .Table }}), {{ .To }}}{{ end -}} }{{ else }}NULL{{ end }}, {{ len .Edges }}}{{ end -}} })",
+		Longest: "longest({{ .MatcherFuncA }})",
+		Table:   "const int table{{ .N }}[] = { {{- range $i, $r := .Ranges }}{{ if $i }}, {{ end }}{{ quote .Lo }}, {{ quote .Hi }}{{ end -}} };",
+	})
+	if err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	return execute(tmpl, data)
 }
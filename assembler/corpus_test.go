@@ -0,0 +1,323 @@
+package assembler_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"thompson-regex/assembler"
+	"thompson-regex/compiler"
+)
+
+// corpus pairs expressions with inputs on which every backend must agree with
+// the standard library.
+var corpus = map[string][]string{
+	"a*a":                    {"aaa", "baab", "b"},
+	"(a|ab)c":                {"abc", "xabcac"},
+	"(a|ab)(c|bcd)":          {"abcd"},
+	"a(b|c)*d":               {"abccd xad", "abc"},
+	"(a*)*b":                 {"aab", "b"},
+	"(ab)+|c":                {"ababcab"},
+	"a*":                     {"baaab", "", "b"},
+	"(?s)a.b":                {"a\nb axb"},
+	"(a|b)*abb":              {"aababb"},
+	"(0|1)+":                 {"a101b0"},
+	"[a-c]+x":                {"abxcax", "dx"},
+	"[^ab]+":                 {"xabyz"},
+	"0x[0-9a-fA-F]+":         {"0x1F 0xg 0xabc"},
+	`\(a\|b\)\*`:             {"x(a|b)*y"},
+	`\'[^\']*\'`:             {"it's 'quoted' ok"},
+	`a\\b`:                   {`xa\by`},
+	`[\\\]\-]+`:              {`a\]-b`},
+	`\x41\x{42}+`:            {"ABBBC"},
+	`a\tb\ c`:                {"a\tb c"},
+	"colou?r":                {"color colour colouur"},
+	"a.c":                    {"abc a\nc a.c"},
+	"x.?y":                   {"xy xzy xzzy"},
+	"(ab)?.*":                {"abab\nc"},
+	"a{2,3}":                 {"a aa aaaa aaaaaaa"},
+	"(ab){2}|b{2,}":          {"abababbbb"},
+	"x(a*){2,3}y":            {"xaay xy"},
+	`[0-9]{3}\-?[0-9]{0,4}`:  {"555-1234 12-3456 1234567"},
+	"^a+":                    {"aab aa", "baa"},
+	"b*$":                    {"abb", "ba", ""},
+	"^$":                     {"", "a"},
+	"^(a|b)$|^c":             {"a", "ab", "cc"},
+	"$|a":                    {"aa"},
+	"café|naïve":             {"a café, naïve ça", "cafe"},
+	"日本語?":                   {"日本 日本語語"},
+	`[à-ÿ]+|[^a-z\ ]`:        {"voilà ça 😀 ok"},
+	"a.b":                    {"aéb a😀b axxb"},
+	"((a)|b)+":               {"abba"},
+	"(a*){2,}b":              {"aab", "b"},
+	"(a(b)?)+":               {"aba"},
+	`([0-9]{4})\-([0-9]{2})`: {"2024-01 and 1999-12", "24-01"},
+	`(\"[^\"]*\")|(\\\")`:    {"say \"hi\" \\\" \"é\tx\""},
+	`(?P<year>[0-9]{4})\-(?P<month>[0-9]{2})(\-([0-9]{2}))?`: {"2024-01-31 1999-12"},
+	"(?:ab)+(c)|(?:x(?P<y>y))":                               {"ababc xy abx"},
+	`\<(.*?)\>`:                                              {"<a><bc> <>"},
+	"a+?|b*?c":                                               {"aab bbc c"},
+	"x(a|b)??b":                                              {"xab xb"},
+	"a{2,4}?(a*)":                                            {"aaaaa"},
+	"x(a*?)b|(a+?)+c":                                        {"xaab aac xb"},
+	"(a??)(a{0,2}?)x":                                        {"aax ax"},
+	`\d+(\.\d+)?`:                                            {"pi is 3.14, e 2.7.1 ٣"},
+	`[\w\-]+|\S\s`:                                           {"a_b-9 é! x"},
+	`[^\d\s]+|\D\W`:                                          {"ab1 c\td2e", "é."},
+	`\p{Greek}+|[\p{Lu}\d]+`:                                 {"Καλημέρα κόσμε ABC12 xyz"},
+	`\P{L}+|\pN\p{^L}`:                                       {"ab, 12; ½!"},
+	`(\p{Han}+)(\p{Latin}*)`:                                 {"日本語abc 漢字 x"},
+	`\p{Cs}|[\p{Cs}x]\pL`:                                    {"x\ufffd \ufffdy xy"},
+	`(?i)straße|k+`:                                          {"STRASSE STRAẞE Straße KkK"},
+	`(?i)[^k\d]+`:                                            {"aKb1K\u212ac"},
+	`a(?i)b|c`:                                               {"aB C c AB"},
+	`x(?i:A)a`:                                               {"xaa xAa xAA"},
+	`(?m)^\w+$`:                                              {"ab\ncd\n\nef g"},
+	`(?ms)^a.b$`:                                             {"x\na b\nacb"},
+	`(?m)^|$`:                                                {"a\nb\n"},
+	`(?i)\W+|\p{Lu}+`:                                        {"ſK! abc ÉÈ"},
+	`(?i)(é)(?-i:É)`:                                         {"éÉ ÉÉ éé"},
+	"":                                                       {"", "ab"},
+	"()":                                                     {"ab"},
+	"(|a)b|c":                                                {"ab b c"},
+	"a||b":                                                   {"abc"},
+	"x(|a|)(b|)*":                                            {"xab xb x"},
+	`(\<.)|(\&)|(\t.)|(é.)`:                                  {"<&x\t\b \t\f \t\x01 é\xff é\\ \"é\""},
+}
+
+// unicodeCorpus pairs expressions parsed with UnicodeClasses with their
+// equivalents in the syntax of the standard library, and with inputs.
+var unicodeCorpus = map[string]struct {
+	std    string
+	inputs []string
+}{
+	`\d+`:     {`\p{Nd}+`, []string{"12 ٣٤ ９ ½"}},
+	`[\w\-]+`: {`[\p{L}\p{M}\p{Nd}\p{Pc}\-]+`, []string{"naïve café-au-lait 日本語 a\u0301b ⁀x"}},
+	`\S+\s`:   {`[^\s\p{Zs}\x{85}\x{2028}\x{2029}\v]+[\s\p{Zs}\x{85}\x{2028}\x{2029}\v]`, []string{"a\u00a0b\u2003c d"}},
+}
+
+// operatorCorpus pairs expressions with the operators '&' and '~', which the
+// standard library lacks, with equivalents in its syntax, and with inputs.
+var operatorCorpus = map[string]struct {
+	std    string
+	inputs []string
+}{
+	"a+&(aa)*":            {"(?:aa)+", []string{"aaaaa a aa"}},
+	"[a-c]+&~(.*bb.*)":    {"(?:[ac]|b[ac])+b?|b", []string{"abba cbbbc"}},
+	"(?s)~(.*a.*)":        {"[^a]*", []string{"xay b", "aa"}},
+	"(x)(?s:~(.*x.*))":    {"(x)[^x]*", []string{"xyxzx"}},
+	"~(a|b)&[a-c]{2}|日~本": {"[a-c]{2}|日(?:[^本].*|本.+)?", []string{"ab a 日本日 日本語"}},
+}
+
+// posixCorpus pairs expressions in POSIX syntax with their equivalents in
+// ERE, on which the leftmost-longest matches of the standard library are
+// found, and with inputs.
+var posixCorpus = map[string]struct {
+	syntax compiler.Syntax
+	std    string
+	inputs []string
+}{
+	"(a|ab)(c|bcd)(d*)":         {compiler.ERE, "(a|ab)(c|bcd)(d*)", []string{"abcd", "xabcdd"}},
+	"(wee|week)(knights|night)": {compiler.ERE, "(wee|week)(knights|night)", []string{"weeknights"}},
+	"a*|b":                      {compiler.ERE, "a*|b", []string{"aab b", ""}},
+	"x*y+|xy*z":                 {compiler.ERE, "x*y+|xy*z", []string{"xyyz xxyy"}},
+	"[[:alpha:]]+[[:digit:]]*":  {compiler.ERE, "[[:alpha:]]+[[:digit:]]*", []string{"abc123 x9 7"}},
+	"[]a-]+|[^[:space:]]":       {compiler.ERE, "[]a-]+|[^[:space:]]", []string{"a]-b c"}},
+	`\(ab*\)\{2\}c`:             {compiler.BRE, "(ab*){2}c", []string{"abbac aac"}},
+	"^*a+b?":                    {compiler.BRE, `^\*a\+b\?`, []string{"*a+b?", "*aab"}},
+	`x\(a*|b\)$`:                {compiler.BRE, `x(a*\|b)$`, []string{"xa|b", "xab"}},
+}
+
+// goCorpus pairs expressions in the syntax of the standard library, which are
+// imported from its parser rather than parsed by ParseTree, with inputs.
+var goCorpus = map[string][]string{
+	`\Qa.b\E+|[[:^alpha:]\d]+`: {"a.bb a.b. x1-2"},
+	"(?U)(a+)(b*)":             {"aab ab"},
+	`(?i)k(?P<e>\x{e9}+)`:      {"KÉé ké k"},
+	`\Ax{2,3}|y{0}z$`:          {"xxxx z yz"},
+	`(?s:.)x|.y|\z`:            {"ax by"},
+	"(a|b|ab)c|x{2}":           {"abc xxx bc"},
+}
+
+// treeCorpus pairs trees that no expression parses to with expressions in the
+// syntax of the standard library that match the same, and with inputs.
+var treeCorpus = map[string]struct {
+	tree   compiler.Node
+	inputs []string
+}{
+	"a":                   {&compiler.Alternate{A: &compiler.NoMatch{}, B: &compiler.Literal{Rune: 'a'}}, []string{"aba"}},
+	"b":                   {&compiler.Concat{A: &compiler.Literal{Rune: 'b'}, B: &compiler.Star{Sub: &compiler.NoMatch{}}}, []string{"abb"}},
+	`a[^\x00-\x{10FFFF}]`: {&compiler.Concat{A: &compiler.Literal{Rune: 'a'}, B: &compiler.NoMatch{}}, []string{"aa"}},
+}
+
+// A backend builds the generated source into a command that runs it.
+type backend struct {
+	lang  string
+	tool  string
+	build func(dir, src string) ([]string, error)
+}
+
+var backends = []backend{
+	{"golang", "go", func(dir, src string) ([]string, error) {
+		bin := filepath.Join(dir, "matcher")
+		return []string{bin}, run(dir, src, "main.go", "go", "build", "-o", bin, "main.go")
+	}},
+	{"c", "gcc", func(dir, src string) ([]string, error) {
+		bin := filepath.Join(dir, "matcher")
+		return []string{bin}, run(dir, src, "main.c", "gcc", "-o", bin, "main.c")
+	}},
+	{"python3", "python3", func(dir, src string) ([]string, error) {
+		return []string{"python3", filepath.Join(dir, "main.py")}, run(dir, src, "main.py", "python3", "-m", "py_compile", "main.py")
+	}},
+}
+
+// run writes src to the named file in dir and runs the command there.
+func run(dir, src, name string, command ...string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+		return err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+	return nil
+}
+
+func TestCorpus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping generated programs in short mode")
+	}
+	for _, be := range backends {
+		if _, err := exec.LookPath(be.tool); err != nil {
+			t.Logf("skipping %s: %s", be.lang, err)
+			continue
+		}
+		for expr, inputs := range corpus {
+			testBackend(t, be, expr, parse(t, expr, 0), regexp.MustCompile(expr), inputs)
+		}
+		for expr, c := range unicodeCorpus {
+			testBackend(t, be, expr, parse(t, expr, compiler.UnicodeClasses), regexp.MustCompile(c.std), c.inputs)
+		}
+		for expr, c := range operatorCorpus {
+			testBackend(t, be, expr, parse(t, expr, 0), regexp.MustCompile(c.std), c.inputs)
+		}
+		for expr, c := range posixCorpus {
+			prog, err := compiler.Parse(expr, compiler.Options{Syntax: c.syntax})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testBackend(t, be, expr, prog.Tree, regexp.MustCompilePOSIX(c.std), c.inputs)
+		}
+		for expr, inputs := range goCorpus {
+			prog, err := compiler.Parse(expr, compiler.Options{Syntax: compiler.Go})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testBackend(t, be, expr, prog.Tree, regexp.MustCompile(expr), inputs)
+		}
+		for std, c := range treeCorpus {
+			testBackend(t, be, compiler.RPN(c.tree), c.tree, regexp.MustCompile(std), c.inputs)
+		}
+	}
+}
+
+// parse returns the tree of the expression, parsed with the flags.
+func parse(t *testing.T, expr string, flags compiler.Flags) compiler.Node {
+	tree, err := compiler.ParseTree(expr, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// testBackend checks that the program the backend generates for the tree of
+// the expression finds the same matches as std.
+func testBackend(t *testing.T, be backend, expr string, tree compiler.Node, std *regexp.Regexp, inputs []string) {
+	root, err := compiler.Compile(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := assembler.Assemblers[be.lang](root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := be.build(t.TempDir(), src)
+	if err != nil {
+		t.Fatalf("%s %q: %s", be.lang, expr, err)
+	}
+	for _, input := range inputs {
+		out, err := exec.Command(command[0], append(command[1:], input)...).Output()
+		if err != nil {
+			t.Fatalf("%s %q on %q: %s", be.lang, expr, input, err)
+		}
+		var want strings.Builder
+		if std.NumSubexp() > 0 {
+			for _, line := range submatches(std, input) {
+				want.WriteString(line + "\n")
+			}
+		} else {
+			for _, match := range std.FindAllString(input, -1) {
+				want.WriteString(jsonString(match) + "\n")
+			}
+		}
+		if got := string(out); got != want.String() {
+			t.Fatalf("%s %q on %q: expected %q got %q", be.lang, expr, input, want.String(), got)
+		}
+	}
+}
+
+// submatches returns the JSON lines that a generated program should print for
+// the matches of re in the input, keyed by group name or else number, in the
+// order of the groups.
+func submatches(re *regexp.Regexp, input string) []string {
+	var lines []string
+	for _, loc := range re.FindAllStringSubmatchIndex(input, -1) {
+		var b strings.Builder
+		b.WriteString("{")
+		for n := 0; n < len(loc); n += 2 {
+			if n > 0 {
+				b.WriteString(", ")
+			}
+			field := strconv.Itoa(n / 2)
+			if name := re.SubexpNames()[n/2]; name != "" {
+				field = name
+			}
+			value := "null"
+			if loc[n] >= 0 {
+				value = jsonString(input[loc[n]:loc[n+1]])
+			}
+			fmt.Fprintf(&b, "%s: %s", jsonString(field), value)
+		}
+		b.WriteString("}")
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// jsonString quotes s as the generated programs do: only quotes, backslashes
+// and control characters are escaped, by the short escapes where JSON has
+// them, and an invalid encoding is written as U+FFFD.
+func jsonString(s string) string {
+	short := map[rune]string{'"': `\"`, '\\': `\\`, '\b': `\b`, '\f': `\f`, '\n': `\n`, '\r': `\r`, '\t': `\t`}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch escape, ok := short[r]; {
+		case ok:
+			b.WriteString(escape)
+		case r < 0x20:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -1,6 +1,8 @@
 package assembler
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -29,67 +31,309 @@ type MatcherGenerator interface {
 type CodeGenerators struct {
 	Rune       func(rune) (string, error)
 	Or, Concat func(string, string) (string, error)
-	Closure    func(string, int) (string, error)
+	// Closure receives the least and greatest number of occurrences, the
+	// latter negative when unbounded, and whether to prefer fewer.
+	Closure func(a string, min, max int, lazy bool) (string, error)
+	// Class receives pairs of inclusive bounds.
+	Class func(ranges []rune, negated bool) (string, error)
+	// Any matches any character, except newline unless nl is set.
+	Any func(nl bool) (string, error)
+	// Optional matches zero or one occurrences, preferring one unless lazy.
+	Optional func(a string, lazy bool) (string, error)
+	// Begin and End match the empty string at the beginning and end of the
+	// input or, if line is set, of any line.
+	Begin, End func(line bool) (string, error)
+	// Group captures what its matcher matches as the submatch numbered n,
+	// counting from 1, which is named name unless that is empty.
+	Group func(a string, n int, name string) (string, error)
+	// Empty matches the empty string, and Fail matches nothing.
+	Empty, Fail func() (string, error)
+	// Automaton matches the strings that a deterministic automaton accepts
+	// from the state numbered start, preferring the longest.
+	Automaton func(start int, states []AutomatonState) (string, error)
+	// Longest matches the longest of the matches of its matcher, and among
+	// those the first it finds.
+	Longest func(a string) (string, error)
+}
+
+// An AutomatonState is a state of an automaton given to Automaton, which
+// moves on each of its Edges to the state numbered To on any rune in Ranges,
+// pairs of inclusive bounds, and accepts if Accepting is set.
+type AutomatonState struct {
+	Accepting bool
+	Edges     []Edge
+}
+
+// An Edge is a transition of an AutomatonState.
+type Edge struct {
+	Ranges []rune
+	To     int
+}
+
+// templates holds the source of the templates from which an assembler's
+// CodeGenerators are made.
+//
+// Rune is executed on the rune; Or and Concat on MatcherFuncA and
+// MatcherFuncB; Closure on MatcherFuncA, Min, Max and Lazy; Class on Negated
+// and Table; Any on NL; Optional on MatcherFuncA and Lazy; Group on
+// MatcherFuncA, N and Name; Begin and End on Line; Empty and Fail on nothing;
+// Automaton on Start and States, each of which has Accepting and Edges, each
+// of which has Table and To; and Longest on MatcherFuncA. Table is executed
+// on N and Ranges, a list of Lo and Hi bounds, once for each distinct set of
+// ranges that classes and edges check, which are numbered from 0 and given to
+// them as Table. The templates may call quote, which is Quote, to write a
+// rune as a literal of the output language.
+type templates struct {
+	Rune, Or, Concat, Closure, Class, Any, Optional  string
+	Begin, End, Group, Table, Empty, Fail, Automaton string
+	Longest                                          string
+	Quote                                            func(rune) string
+}
+
+// A program is the data on which an assembler's template for the whole
+// program is executed: the Matcher for the expression, the Fields under which
+// a match and then each of its submatches are printed, which are their numbers
+// or, for named groups, their names, and the range Tables of its classes and
+// automata, which are shared between those with the same ranges so that large
+// Unicode classes are written out once.
+type program struct {
+	Matcher string
+	Fields  []string
+	Tables  []string
+}
+
+// generate returns the program for the expression of root, represented with
+// the templates.
+func generate(root MatcherGenerator, t templates) (*program, error) {
+	prog := &program{}
+	gens, err := codeGens(t, prog)
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	groups := 0
+	group := gens.Group
+	gens.Group = func(a string, n int, name string) (string, error) {
+		if n > groups {
+			groups = n
+		}
+		names[n] = name
+		return group(a, n, name)
+	}
+	matcher, err := root.Generate(gens)
+	if err != nil {
+		return nil, err
+	}
+	prog.Matcher = matcher
+	prog.Fields = make([]string, groups+1)
+	for n := range prog.Fields {
+		prog.Fields[n] = strconv.Itoa(n)
+		if names[n] != "" {
+			prog.Fields[n] = names[n]
+		}
+	}
+	return prog, nil
+}
+
+// A runeRange is an inclusive range of runes, as given to Class templates.
+type runeRange struct {
+	Lo, Hi rune
+}
+
+// execute runs the template on data and returns the output.
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 // codeGens returns CodeGenerators which can be provided to each
 // MatcherGenerator enabling it to represent matching in the format configured
-// in the assembler.
-func codeGens(char, or, concat, closure string) (*CodeGenerators, error) {
-	tmplRune, err := template.New("c").Parse(char)
+// in the assembler. The tables of classes are added to prog.
+func codeGens(t templates, prog *program) (*CodeGenerators, error) {
+	funcs := template.FuncMap{"quote": t.Quote}
+	tmplRune, err := template.New("c").Funcs(funcs).Parse(t.Rune)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplOr, err := template.New("cnode").Funcs(funcs).Parse(t.Or)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplConcat, err := template.New("concat").Funcs(funcs).Parse(t.Concat)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplClosure, err := template.New("closure").Funcs(funcs).Parse(t.Closure)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplClass, err := template.New("class").Funcs(funcs).Parse(t.Class)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplAny, err := template.New("any").Funcs(funcs).Parse(t.Any)
 	if err != nil {
 		return nil, err
 	}
 
-	tmplOr, err := template.New("cnode").Parse(or)
+	tmplOptional, err := template.New("optional").Funcs(funcs).Parse(t.Optional)
 	if err != nil {
 		return nil, err
 	}
 
-	tmplConcat, err := template.New("concat").Parse(concat)
+	tmplBegin, err := template.New("begin").Funcs(funcs).Parse(t.Begin)
 	if err != nil {
 		return nil, err
 	}
 
-	tmplClosure, err := template.New("closure").Parse(closure)
+	tmplEnd, err := template.New("end").Funcs(funcs).Parse(t.End)
 	if err != nil {
 		return nil, err
 	}
+
+	tmplGroup, err := template.New("group").Funcs(funcs).Parse(t.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplTable, err := template.New("table").Funcs(funcs).Parse(t.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplEmpty, err := template.New("empty").Funcs(funcs).Parse(t.Empty)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplFail, err := template.New("fail").Funcs(funcs).Parse(t.Fail)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplAutomaton, err := template.New("automaton").Funcs(funcs).Parse(t.Automaton)
+	if err != nil {
+		return nil, err
+	}
+
+	tmplLongest, err := template.New("longest").Funcs(funcs).Parse(t.Longest)
+	if err != nil {
+		return nil, err
+	}
+	tables := map[string]int{}
+	// table returns the number of the table of the ranges, adding it to prog
+	// if it is the first with them
+	table := func(ranges []rune) (int, error) {
+		key := fmt.Sprint(ranges)
+		if n, ok := tables[key]; ok {
+			return n, nil
+		}
+		data := struct {
+			N      int
+			Ranges []runeRange
+		}{N: len(prog.Tables)}
+		for i := 0; i < len(ranges); i += 2 {
+			data.Ranges = append(data.Ranges, runeRange{ranges[i], ranges[i+1]})
+		}
+		table, err := execute(tmplTable, data)
+		if err != nil {
+			return 0, err
+		}
+		tables[key] = len(prog.Tables)
+		prog.Tables = append(prog.Tables, table)
+		return tables[key], nil
+	}
 	return &CodeGenerators{
 		Rune: func(c rune) (string, error) {
-			var buf strings.Builder
-			if err := tmplRune.Execute(&buf, string(c)); err != nil {
-				return "", err
-			}
-			return buf.String(), nil
+			return execute(tmplRune, c)
 		},
 		Concat: func(a, b string) (string, error) {
-			var buf strings.Builder
-			if err := tmplConcat.Execute(&buf, struct {
+			return execute(tmplConcat, struct {
 				MatcherFuncA, MatcherFuncB string
-			}{a, b}); err != nil {
-				return "", err
-			}
-			return buf.String(), nil
+			}{a, b})
 		},
 		Or: func(a, b string) (string, error) {
-			var buf strings.Builder
-			if err := tmplOr.Execute(&buf, struct {
+			return execute(tmplOr, struct {
 				MatcherFuncA, MatcherFuncB string
-			}{a, b}); err != nil {
+			}{a, b})
+		},
+		Closure: func(a string, min, max int, lazy bool) (string, error) {
+			return execute(tmplClosure, struct {
+				MatcherFuncA string
+				Min, Max     int
+				Lazy         bool
+			}{a, min, max, lazy})
+		},
+		Class: func(ranges []rune, negated bool) (string, error) {
+			n, err := table(ranges)
+			if err != nil {
 				return "", err
 			}
-			return buf.String(), nil
+			return execute(tmplClass, struct {
+				Negated bool
+				Table   int
+			}{negated, n})
 		},
-		Closure: func(a string, min int) (string, error) {
-			var buf strings.Builder
-			if err := tmplClosure.Execute(&buf, struct {
+		Any: func(nl bool) (string, error) {
+			return execute(tmplAny, struct{ NL bool }{nl})
+		},
+		Optional: func(a string, lazy bool) (string, error) {
+			return execute(tmplOptional, struct {
 				MatcherFuncA string
-				Min          int
-			}{a, min}); err != nil {
-				return "", err
+				Lazy         bool
+			}{a, lazy})
+		},
+		Begin: func(line bool) (string, error) {
+			return execute(tmplBegin, struct{ Line bool }{line})
+		},
+		End: func(line bool) (string, error) {
+			return execute(tmplEnd, struct{ Line bool }{line})
+		},
+		Group: func(a string, n int, name string) (string, error) {
+			return execute(tmplGroup, struct {
+				MatcherFuncA, Name string
+				N                  int
+			}{a, name, n})
+		},
+		Empty: func() (string, error) {
+			return execute(tmplEmpty, nil)
+		},
+		Fail: func() (string, error) {
+			return execute(tmplFail, nil)
+		},
+		Automaton: func(start int, states []AutomatonState) (string, error) {
+			type edge struct{ Table, To int }
+			type state struct {
+				Accepting bool
+				Edges     []edge
 			}
-			return buf.String(), nil
+			data := struct {
+				Start  int
+				States []state
+			}{Start: start}
+			for _, st := range states {
+				s := state{Accepting: st.Accepting}
+				for _, e := range st.Edges {
+					n, err := table(e.Ranges)
+					if err != nil {
+						return "", err
+					}
+					s.Edges = append(s.Edges, edge{n, e.To})
+				}
+				data.States = append(data.States, s)
+			}
+			return execute(tmplAutomaton, data)
+		},
+		Longest: func(a string) (string, error) {
+			return execute(tmplLongest, struct{ MatcherFuncA string }{a})
 		},
 	}, nil
 }
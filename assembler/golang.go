@@ -1,9 +1,6 @@
 package assembler
 
-import (
-	"strings"
-	"text/template"
-)
+import "text/template"
 
 func Go(root MatcherGenerator) (string, error) {
 	tmpl, err := template.New("program").Parse(`package main
@@ -12,133 +9,401 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 // A matcher represents the compiled code for matching a particular expression.
+// match tries to match the input from index i, calling k with the index after
+// each way of matching in order of preference until k accepts one.
 type matcher interface {
-	match(input []rune) (bool, int)
+	match(input []rune, i int, k func(int) bool) bool
 }
 
 // A char is a matcher for the given rune.
 type char rune
 
-func (c char) match(input []rune) (bool, int) {
-	if len(input) > 0 {
-		return input[0] == rune(c), 1
+func (c char) match(input []rune, i int, k func(int) bool) bool {
+	return i < len(input) && input[i] == rune(c) && k(i+1)
+}
+
+// a class is a matcher for any rune within (or, if negated, outside) the
+// given ranges, listed in order as pairs of inclusive bounds
+type class struct {
+	negated bool
+	ranges  []rune
+}
+
+func (cl class) match(input []rune, i int, k func(int) bool) bool {
+	return i < len(input) && inRanges(cl.ranges, input[i]) != cl.negated && k(i+1)
+}
+
+// inRanges reports whether r lies within the ranges, listed in order as pairs
+// of inclusive bounds
+func inRanges(ranges []rune, r rune) bool {
+	// binary search for the range holding r
+	for lo, hi := 0, len(ranges)/2; lo < hi; {
+		m := (lo + hi) / 2
+		if r < ranges[2*m] {
+			hi = m
+		} else if r > ranges[2*m+1] {
+			lo = m + 1
+		} else {
+			return true
+		}
 	}
-	return false, 0
+	return false
 }
 
-// an or is a matcher for strings matching any of the given matchers
+// a dot is a matcher for any rune, except newline unless it is set
+type dot bool
+
+func (nl dot) match(input []rune, i int, k func(int) bool) bool {
+	return i < len(input) && (bool(nl) || input[i] != '\n') && k(i+1)
+}
+
+// begin is a matcher for the empty string at the beginning of the input or,
+// if it is set, of any line
+type begin bool
+
+func (line begin) match(input []rune, i int, k func(int) bool) bool {
+	return (i == 0 || bool(line) && input[i-1] == '\n') && k(i)
+}
+
+// end is a matcher for the empty string at the end of the input or, if it is
+// set, of any line
+type end bool
+
+func (line end) match(input []rune, i int, k func(int) bool) bool {
+	return (i == len(input) || bool(line) && input[i] == '\n') && k(i)
+}
+
+// an or is a matcher for strings matching any of the given matchers, which are
+// tried in order; with none, it matches nothing
 type or []matcher
 
-func (matchers or) match(input []rune) (bool, int) {
+func (matchers or) match(input []rune, i int, k func(int) bool) bool {
 	for _, m := range matchers {
-		if ok, n := m.match(input); ok {
-			return true, n
-		}		
+		if m.match(input, i, k) {
+			return true
+		}
 	}
-	return false, 0
+	return false
 }
 
 // concat is a matcher for strings matching the concatenation of the
-// given matchers
+// given matchers; with none, it matches the empty string
 type concat []matcher
 
-func (matchers concat) match(input []rune) (bool, int) {
-	p := 0
-	for _, m := range matchers {
-		if ok, n := m.match(input[p:]); ok {
-			p += n
-			continue
+func (matchers concat) match(input []rune, i int, k func(int) bool) bool {
+	if len(matchers) == 0 {
+		return k(i)
+	}
+	return matchers[0].match(input, i, func(j int) bool {
+		return matchers[1:].match(input, j, k)
+	})
+}
+
+// closure is single matcher for strings matching the closure of the
+// given matcher, with at least min and (unless it is negative) at most max
+// occurrences, preferring as many as possible or, if lazy, as few
+type closure struct {
+	m        matcher
+	min, max int
+	lazy     bool
+}
+
+func (cl closure) match(input []rune, i int, k func(int) bool) bool {
+	return cl.repeat(input, i, 0, k)
+}
+
+// repeat matches the occurrences that follow the first count
+func (cl closure) repeat(input []rune, i, count int, k func(int) bool) bool {
+	if cl.lazy && count >= cl.min && k(i) {
+		return true
+	}
+	if count != cl.max && cl.m.match(input, i, func(j int) bool {
+		// an occurrence that matches nothing can't lead anywhere new: as in
+		// Go's regexp, an unbounded closure ends at the first such occurrence
+		// after the mandatory ones (or the last of them), and fails at later
+		// ones
+		if last := cl.min - 1; j == i && cl.max < 0 && count >= last {
+			return (count == last || count == 0) && k(j)
 		}
-		return false, p
+		return cl.repeat(input, j, count+1, k)
+	}) {
+		return true
 	}
-	return true, p
+	return !cl.lazy && count >= cl.min && k(i)
+}
+
+// optional is a matcher for strings matching the given matcher or empty,
+// preferring the former or, if lazy, the latter
+type optional struct {
+	m    matcher
+	lazy bool
 }
 
-// kmatcher returns a single matcher for strings matching exactly k occurrences
-// of the given matcher
-func kmatcher(m matcher, k int) matcher {
-	matchers := make([]matcher, k)
-	for i := 0; i < k; i++ {
-		matchers[i] = m
+func (o optional) match(input []rune, i int, k func(int) bool) bool {
+	if o.lazy {
+		return k(i) || o.m.match(input, i, k)
 	}
-	return concat(matchers)
+	return o.m.match(input, i, k) || k(i)
 }
 
-// closure is single matcher for strings matching the closure of the
-// given matcher
-type closure struct {
+// an automaton is a matcher for the strings that a deterministic automaton
+// accepts from the state numbered start, preferring the longest
+type automaton struct {
+	start  int
+	states []state
+}
+
+// a state of an automaton accepts if it is accepting, and moves on each edge
+// to the state numbered to on any rune within the ranges
+type state struct {
+	accepting bool
+	edges     []edge
+}
+
+type edge struct {
+	ranges []rune
+	to     int
+}
+
+func (a automaton) match(input []rune, i int, k func(int) bool) bool {
+	return a.run(input, i, a.start, k)
+}
+
+// run matches from the state s, trying the longer matches first
+func (a automaton) run(input []rune, i, s int, k func(int) bool) bool {
+	if i < len(input) {
+		for _, e := range a.states[s].edges {
+			if inRanges(e.ranges, input[i]) {
+				if a.run(input, i+1, e.to, k) {
+					return true
+				}
+				break
+			}
+		}
+	}
+	return a.states[s].accepting && k(i)
+}
+
+// caps holds the bounds of the match being tried and then of each of its
+// submatches, -1 where a group has not matched
+var caps []int
+
+// longest is a matcher for the longest of the strings matching the given
+// matcher and, among those, the first it matches
+type longest struct {
 	m matcher
-	min int
 }
 
-func (cl closure) match(input []rune) (bool, int) {
-	// match min occurrences first
-	if ok, n := kmatcher(cl.m, cl.min).match(input); ok {
-		// then match 1 or more additional 
-		nextcl := closure{cl.m, 1}
-		if ok, subn := nextcl.match(input[n:]); ok {
-			n += subn
+func (l longest) match(input []rune, i int, k func(int) bool) bool {
+	// every match is tried, keeping the submatches of the longest
+	best, saved := -1, []int(nil)
+	l.m.match(input, i, func(j int) bool {
+		if j > best {
+			best, saved = j, append([]int(nil), caps...)
 		}
-		return true, n
+		return false
+	})
+	if best < 0 {
+		return false
+	}
+	restore := append([]int(nil), caps...)
+	copy(caps, saved)
+	if k(best) {
+		return true
 	}
-	return false, 0
+	copy(caps, restore)
+	return false
+}
+
+// a group is a matcher recording the bounds of what the given matcher matches
+// as submatch n
+type group struct {
+	n int
+	m matcher
+}
+
+func (g group) match(input []rune, i int, k func(int) bool) bool {
+	return g.m.match(input, i, func(j int) bool {
+		lo, hi := caps[2*g.n], caps[2*g.n+1]
+		caps[2*g.n], caps[2*g.n+1] = i, j
+		if k(j) {
+			return true
+		}
+		caps[2*g.n], caps[2*g.n+1] = lo, hi
+		return false
+	})
 }
 
+// fields names the match and then each of its submatches in the output
+var fields = []string{ {{- range $n, $field := .Fields }}{{ if $n }}, {{ end }}{{ printf "%q" $field }}{{ end -}} }
+
+// find returns the bounds of the leftmost match that begins at or after pos
+// followed by those of its submatches, or nil if there is none.
+func find(m matcher, input []rune, pos int) []int {
+	for start := pos; start <= len(input); start++ {
+		caps = make([]int, 2*len(fields))
+		for i := range caps {
+			caps[i] = -1
+		}
+		if m.match(input, start, func(j int) bool {
+			caps[0], caps[1] = start, j
+			return true
+		}) {
+			return caps
+		}
+	}
+	return nil
+}
+
+// jsonString quotes s as a JSON string. Only quotes, backslashes and control
+// characters are escaped, by the short escapes where JSON has them, so that
+// the programs of every output language print the same bytes.
+func jsonString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString("\\b")
+		case '\f':
+			b.WriteString("\\f")
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, "\\u%04x", r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// submatches returns the match and its submatches as a JSON object keyed by
+// fields, with null for groups that did not match.
+func submatches(input []rune, caps []int) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for n, field := range fields {
+		if n > 0 {
+			b.WriteString(", ")
+		}
+		value := "null"
+		if caps[2*n] >= 0 {
+			value = jsonString(string(input[caps[2*n]:caps[2*n+1]]))
+		}
+		fmt.Fprintf(&b, "%s: %s", jsonString(field), value)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+{{ if .Tables -}}
+// the tables list the ranges of the classes and automata
+{{ range .Tables }}{{ . }}
+{{ end }}
+{{ end -}}
 func main() {
 	if len(os.Args) != 2 {
 		log.Fatalln("must supply input string")
 	}
 	input := []rune(os.Args[1])
 
-	expmatcher := {{ . }}
+	expmatcher := {{ .Matcher }}
 
-	matches := []string{}
-	for i := 0; i < len(input); {
-		if ok, n := expmatcher.match(input[i:]); ok {
-			matches = append(matches, string(input[i:i+n]))
-			i += n
+	// an empty match right after a previous match is ignored
+	for pos, prevEnd := 0, -1; pos <= len(input); {
+		caps := find(expmatcher, input, pos)
+		if caps == nil {
+			break
+		}
+		start, end := caps[0], caps[1]
+		accept := end != pos || start != prevEnd
+		if end == pos {
+			pos++
+		} else {
+			pos = end
+		}
+		prevEnd = end
+		if !accept {
 			continue
 		}
-		i++
+		// matches are printed as JSON strings, with the submatches of
+		// expressions with groups in an object
+		if len(fields) > 1 {
+			fmt.Println(submatches(input, caps))
+		} else {
+			fmt.Println(jsonString(string(input[start:end])))
+		}
 	}
-
-	fmt.Printf("%q\n", matches)
 }
 `)
 	if err != nil {
 		return "", err
 	}
 
-	gens, err := codeGens(
-		"char('{{ . }}')",
-		`or{
+	data, err := generate(root, templates{
+		Quote: quoteGo,
+		Rune:  "char({{ quote . }})",
+		Or: `or{
 	{{ .MatcherFuncA }},
 	{{ .MatcherFuncB }},
 }`,
-		`concat{
+		Concat: `concat{
 	{{ .MatcherFuncA }},
 	{{ .MatcherFuncB }},
 }`,
-		`closure{
+		Closure: `closure{
 	{{ .MatcherFuncA }},
 	{{ .Min }},
+	{{ .Max }},
+	{{ .Lazy }},
 }`,
-	)
-	if err != nil {
-		return "", err
-	}
-
-	data, err := root.Generate(gens)
+		Any:   "dot({{ .NL }})",
+		Begin: "begin({{ .Line }})",
+		End:   "end({{ .Line }})",
+		Group: `group{
+	{{ .N }},
+	{{ .MatcherFuncA }},
+}`,
+		Optional: `optional{
+	{{ .MatcherFuncA }},
+	{{ .Lazy }},
+}`,
+		Class: `class{
+	{{ .Negated }},
+	table{{ .Table }},
+}`,
+		Empty: "concat{}",
+		Fail:  "or{}",
+		Automaton: `automaton{
+	{{ .Start }},
+	[]state{
+{{- range .States }}
+		{ {{- .Accepting }}, []edge{ {{- range $i, $e := .Edges }}{{ if $i }}, {{ end }}{table{{ .Table }}, {{ .To }}}{{ end -}} }},
+{{- end }}
+	},
+}`,
+		Longest: `longest{
+	{{ .MatcherFuncA }},
+}`,
+		Table: "var table{{ .N }} = []rune{ {{- range $i, $r := .Ranges }}{{ if $i }}, {{ end }}{{ quote .Lo }}, {{ quote .Hi }}{{ end -}} }",
+	})
 	if err != nil {
 		return "", err
 	}
-
-	var buf strings.Builder
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
+	return execute(tmpl, data)
 }
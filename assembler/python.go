@@ -1,17 +1,22 @@
 package assembler
 
-import (
-	"strings"
-	"text/template"
-)
+import "text/template"
 
 func Python3(root MatcherGenerator) (string, error) {
-	tmpl, err := template.New("program").Parse(`import sys
-from typing import Union
+	tmpl, err := template.New("program").Parse(`import bisect
+import codecs
+import json
+import os
+import sys
+from typing import Callable, List, Tuple
+
+sys.setrecursionlimit(100000)
 
 
 class Matcher():
-    def match(self, inputstr: str) -> Union[bool, int]:
+    # match tries to match inputstr from index i, calling k with the index
+    # after each way of matching in order of preference until k accepts one.
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
         raise Exception("not implemented")
 
     def __or__(self, a):
@@ -20,108 +25,296 @@ class Matcher():
     def __add__(self, a):
         return Concat(self, a)
 
-    def __pow__(self, min: int):
-        return Closure(self, min)
+    def __pow__(self, counts: Tuple[int, int, bool]):
+        return Closure(self, *counts)
 
 
 class Char(Matcher):
     def __init__(self, c: str):
         self.c = c
 
-    def match(self, inputstr: str) -> Union[bool, int]:
-        if len(inputstr) == 0:
-            return False, 0
-        return inputstr[0] == self.c, 1
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return i < len(inputstr) and inputstr[i] == self.c and k(i + 1)
+
+
+# a Class matches any character within (or, if negated, outside) its ranges,
+# listed in order as pairs of inclusive bounds
+class Class(Matcher):
+    def __init__(self, negated: bool, ranges: List[str]):
+        self.negated, self.ranges = negated, ranges
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return (i < len(inputstr)
+                and in_ranges(self.ranges, inputstr[i]) != self.negated
+                and k(i + 1))
+
+
+# in_ranges reports whether c lies within the ranges, listed in order as pairs
+# of inclusive bounds
+def in_ranges(ranges: List[str], c: str) -> bool:
+    # c is inside a range if it follows a lower bound or equals an upper
+    j = bisect.bisect_right(ranges, c)
+    return j % 2 == 1 or (j > 0 and ranges[j - 1] == c)
+
+
+class Dot(Matcher):
+    def __init__(self, nl: bool):
+        self.nl = nl
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return (i < len(inputstr) and (self.nl or inputstr[i] != '\n')
+                and k(i + 1))
+
+
+# Begin and End match the empty string at the beginning and end of the input
+# or, if line is set, of any line
+class Begin(Matcher):
+    def __init__(self, line: bool):
+        self.line = line
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return ((i == 0 or self.line and inputstr[i - 1] == '\n')
+                and k(i))
+
+
+class End(Matcher):
+    def __init__(self, line: bool):
+        self.line = line
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return ((i == len(inputstr) or self.line and inputstr[i] == '\n')
+                and k(i))
+
+
+# Empty matches the empty string, and Fail matches nothing
+class Empty(Matcher):
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return k(i)
+
+
+class Fail(Matcher):
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return False
 
 
 class Or(Matcher):
     def __init__(self, a: Matcher, b: Matcher):
         self.am, self.bm = a.match, b.match
 
-    def match(self, inputstr: str) -> Union[bool, int]:
-        ismatch, n = self.am(inputstr)
-        if ismatch:
-            return True, n
-        return self.bm(inputstr)
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return self.am(inputstr, i, k) or self.bm(inputstr, i, k)
 
 
 class Concat(Matcher):
     def __init__(self, a: Matcher, b: Matcher):
         self.am, self.bm = a.match, b.match
 
-    def match(self, inputstr: str) -> Union[bool, int]:
-        ismatch, n = self.am(inputstr)
-        if not ismatch:
-            return False, 0
-        ismatch, m = self.bm(inputstr[n:])
-        return ismatch, n+m
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return self.am(inputstr, i, lambda j: self.bm(inputstr, j, k))
 
 
+# a Closure prefers as many occurrences as possible or, if lazy, as few
 class Closure(Matcher):
-    def __init__(self, a: Matcher, min: int):
+    def __init__(self, a: Matcher, min: int, max: int, lazy: bool):
         self.am = a.match
         self.min = min
+        self.max = max
+        self.lazy = lazy
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return self.repeat(inputstr, i, 0, k)
+
+    # repeat matches the occurrences that follow the first count
+    def repeat(self, inputstr: str, i: int, count: int,
+               k: Callable[[int], bool]) -> bool:
+        if self.lazy and count >= self.min and k(i):
+            return True
+
+        def more(j: int) -> bool:
+            # an occurrence that matches nothing can't lead anywhere new: as
+            # in Go's regexp, an unbounded closure ends at the first such
+            # occurrence after the mandatory ones (or the last of them), and
+            # fails at later ones
+            last = self.min - 1
+            if j == i and self.max < 0 and count >= last:
+                return (count == last or count == 0) and k(j)
+            return self.repeat(inputstr, j, count + 1, k)
+        if count != self.max and self.am(inputstr, i, more):
+            return True
+        return not self.lazy and count >= self.min and k(i)
+
+
+class Optional(Matcher):
+    def __init__(self, a: Matcher, lazy: bool):
+        self.am = a.match
+        self.lazy = lazy
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        if self.lazy:
+            return k(i) or self.am(inputstr, i, k)
+        return self.am(inputstr, i, k) or k(i)
+
+
+# an Automaton matches the strings that a deterministic automaton accepts from
+# the state numbered start, preferring the longest. Each state is a pair of
+# whether it accepts and its edges, each a pair of ranges and the number of
+# the state it moves to on any character within them.
+class Automaton(Matcher):
+    def __init__(self, start: int,
+                 states: List[Tuple[bool, List[Tuple[List[str], int]]]]):
+        self.start, self.states = start, states
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        return self.run(inputstr, i, self.start, k)
 
-    def match(self, inputstr: str) -> Union[bool, int]:
-        n = 0
-        matches = 0
-        while matches < self.min: 
-            ismatch, m = self.am(inputstr[n:])
-            if not ismatch:
-               return False, n
-            n += m
-            matches += 1
-        while True:
-            ismatch, m = self.am(inputstr[n:])
-            if not ismatch:
-               return True, n
-            n += m
+    # run matches from the state s, trying the longer matches first
+    def run(self, inputstr: str, i: int, s: int,
+            k: Callable[[int], bool]) -> bool:
+        accepting, edges = self.states[s]
+        if i < len(inputstr):
+            for ranges, to in edges:
+                if in_ranges(ranges, inputstr[i]):
+                    if self.run(inputstr, i + 1, to, k):
+                        return True
+                    break
+        return accepting and k(i)
+
+
+# caps holds the bounds of the match being tried and then of each of its
+# submatches, -1 where a group has not matched
+caps: List[int] = []
+
+
+# a Longest matches the longest of the strings its matcher matches and, among
+# those, the first it matches
+class Longest(Matcher):
+    def __init__(self, a: Matcher):
+        self.am = a.match
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        # every match is tried, keeping the submatches of the longest
+        best: List[int] = [-1]
+        saved: List[int] = []
+
+        def keep(j: int) -> bool:
+            if j > best[0]:
+                best[0] = j
+                saved[:] = caps
+            return False
+        self.am(inputstr, i, keep)
+        if best[0] < 0:
+            return False
+        restore = list(caps)
+        caps[:] = saved
+        if k(best[0]):
+            return True
+        caps[:] = restore
+        return False
+
+
+# a Group records the bounds of what its matcher matches as submatch n
+class Group(Matcher):
+    def __init__(self, n: int, a: Matcher):
+        self.n = n
+        self.am = a.match
+
+    def match(self, inputstr: str, i: int, k: Callable[[int], bool]) -> bool:
+        def close(j: int) -> bool:
+            saved = caps[2 * self.n:2 * self.n + 2]
+            caps[2 * self.n:2 * self.n + 2] = [i, j]
+            if k(j):
+                return True
+            caps[2 * self.n:2 * self.n + 2] = saved
+            return False
+        return self.am(inputstr, i, close)
+
+
+# fields names the match and then each of its submatches in the output
+fields = [{{ range $n, $field := .Fields }}{{ if $n }}, {{ end }}{{ printf "%q" $field }}{{ end }}]
+
+
+# find returns the bounds of the leftmost match that begins at or after pos
+# followed by those of its submatches.
+def find(m: Matcher, inputstr: str, pos: int):
+    for start in range(pos, len(inputstr) + 1):
+        caps[:] = [-1] * (2 * len(fields))
+
+        def record(j: int) -> bool:
+            caps[0:2] = [start, j]
+            return True
+        if m.match(inputstr, start, record):
+            return list(caps)
+    return None
+
+
+# submatches returns the match and its submatches as a JSON object keyed by
+# fields, with null for groups that did not match. Only quotes, backslashes and
+# control characters are escaped, as by the programs of the other languages.
+def submatches(inputstr: str, found: List[int]) -> str:
+    return json.dumps({
+        field: inputstr[found[2 * n]:found[2 * n + 1]]
+        if found[2 * n] >= 0 else None
+        for n, field in enumerate(fields)
+    }, ensure_ascii=False)
 
 
 if len(sys.argv) != 2:
     print("must supply input string")
     sys.exit()
 
-inputstr = sys.argv[1]
-
-exprmatcher = {{ . }}
+# as in Go, each byte of an invalid encoding decodes as U+FFFD
+codecs.register_error("fffd", lambda e: ("\ufffd", e.start + 1))
+inputstr = os.fsencode(sys.argv[1]).decode("utf-8", "fffd")
+{{ if .Tables }}
+# the tables list the ranges of the classes and automata
+{{ range .Tables }}{{ . }}
+{{ end }}{{ end }}
+exprmatcher = {{ .Matcher }}
 
-matches = []
-
-i = 0
-while i < len(inputstr):
-    ismatch, n = exprmatcher.match(inputstr[i:])
-    if ismatch:
-        matches += [inputstr[i:i+n]]
-        i += n
+# an empty match right after a previous match is ignored
+pos, prevend = 0, -1
+while pos <= len(inputstr):
+    found = find(exprmatcher, inputstr, pos)
+    if found is None:
+        break
+    start, end = found[0], found[1]
+    accept = end != pos or start != prevend
+    pos = pos + 1 if end == pos else end
+    prevend = end
+    if not accept:
+        continue
+    # matches are printed as JSON strings, with the submatches of
+    # expressions with groups in an object
+    if len(fields) > 1:
+        print(submatches(inputstr, found))
     else:
-        i += 1
-
-print(matches)
+        print(json.dumps(inputstr[start:end], ensure_ascii=False))
 `)
 	if err != nil {
 		return "", err
 	}
 
-	gens, err := codeGens(
-		"Char('{{ . }}')",
-		`({{ .MatcherFuncA }} | {{ .MatcherFuncB }})`,
-		`({{ .MatcherFuncA }} + {{ .MatcherFuncB }})`,
-		"{{ .MatcherFuncA }} ** {{ .Min }}",
-	)
-	if err != nil {
-		return "", err
-	}
-
-	data, err := root.Generate(gens)
+	data, err := generate(root, templates{
+		Quote:    quotePython,
+		Rune:     "Char({{ quote . }})",
+		Or:       `({{ .MatcherFuncA }} | {{ .MatcherFuncB }})`,
+		Concat:   `({{ .MatcherFuncA }} + {{ .MatcherFuncB }})`,
+		Closure:  "({{ .MatcherFuncA }} ** ({{ .Min }}, {{ .Max }}, {{ if .Lazy }}True{{ else }}False{{ end }}))",
+		Begin:    "Begin({{ if .Line }}True{{ else }}False{{ end }})",
+		Group:    "Group({{ .N }}, {{ .MatcherFuncA }})",
+		End:      "End({{ if .Line }}True{{ else }}False{{ end }})",
+		Any:      "Dot({{ if .NL }}True{{ else }}False{{ end }})",
+		Optional: "Optional({{ .MatcherFuncA }}, {{ if .Lazy }}True{{ else }}False{{ end }})",
+		Class:    "Class({{ if .Negated }}True{{ else }}False{{ end }}, table{{ .Table }})",
+		Empty:    "Empty()",
+		Fail:     "Fail()",
+		Automaton: "Automaton({{ .Start }}, [ {{- range $n, $s := .States }}{{ if $n }}, {{ end }}" +
+			"({{ if .Accepting }}True{{ else }}False{{ end }}, [ {{- range $i, $e := .Edges }}{{ if $i }}, {{ end }}(table{{ .Table }}, {{ .To }}){{ end -}} ]){{ end -}} ])",
+		Longest: "Longest({{ .MatcherFuncA }})",
+		Table:   "table{{ .N }} = [ {{- range $i, $r := .Ranges }}{{ if $i }}, {{ end }}{{ quote .Lo }}, {{ quote .Hi }}{{ end -}} ]",
+	})
 	if err != nil {
 		return "", err
 	}
-
-	var buf strings.Builder
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
-
+	return execute(tmpl, data)
 }
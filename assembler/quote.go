@@ -0,0 +1,51 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// The quote functions return a rune as a literal of each output language.
+
+// quoteGo returns a rune literal, or for the surrogate halves, which a rune
+// literal cannot hold, the code point.
+func quoteGo(r rune) string {
+	if !utf8.ValidRune(r) {
+		return fmt.Sprintf("0x%x", r)
+	}
+	return strconv.QuoteRune(r)
+}
+
+// escapes gives the short escapes shared by Python and C.
+var escapes = map[rune]string{
+	'\\': `\\`, '\'': `\'`, '\n': `\n`, '\r': `\r`, '\t': `\t`,
+}
+
+func quotePython(r rune) string {
+	switch e, ok := escapes[r]; {
+	case ok:
+		return "'" + e + "'"
+	case ' ' <= r && r < 0x7f:
+		return "'" + string(r) + "'"
+	case r < 0x100:
+		return fmt.Sprintf(`'\x%02x'`, r)
+	case r < 0x10000:
+		return fmt.Sprintf(`'\u%04x'`, r)
+	}
+	return fmt.Sprintf(`'\U%08x'`, r)
+}
+
+// quoteC returns a character constant for ASCII runes and the code point
+// otherwise, since C's character constants cannot portably hold more.
+func quoteC(r rune) string {
+	switch e, ok := escapes[r]; {
+	case ok:
+		return "'" + e + "'"
+	case ' ' <= r && r < 0x7f:
+		return "'" + string(r) + "'"
+	case r < 0x80:
+		return fmt.Sprintf(`'\x%02x'`, r)
+	}
+	return fmt.Sprintf("0x%x", r)
+}
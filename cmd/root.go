@@ -1,18 +1,33 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"unicode"
 
 	"thompson-regex/assembler"
 	"thompson-regex/compiler"
+	"thompson-regex/dfa"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	outputLang string
+	outputLang     string
+	printRPN       bool
+	printNFA       bool
+	printDFA       bool
+	dotNL          bool
+	unicodeClasses bool
+	foldCase       bool
+	multiLine      bool
+	freeSpacing    bool
+	maxRepeat      int
+	fullMatch      bool
+	syntax         string
 
 	rootCmd = &cobra.Command{
 		Use:   "thompson-regex [expression]",
@@ -34,15 +49,54 @@ purposes only.`,
 			if !ok {
 				log.Fatalf("cannot find output language %q\n", outputLang)
 			}
-			sievedexp, err := compiler.Sieve(args[0])
-			if err != nil {
-				log.Fatalln("cannot sieve:", err)
+			syn, ok := compiler.Syntaxes[syntax]
+			if !ok {
+				log.Fatalf("cannot find syntax %q\n", syntax)
+			}
+			if maxRepeat < 1 {
+				log.Fatalf("--max-repeat must be at least 1, not %d\n", maxRepeat)
+			}
+			var flags compiler.Flags
+			if dotNL {
+				flags |= compiler.DotNL
+			}
+			if unicodeClasses {
+				flags |= compiler.UnicodeClasses
 			}
-			rpnexp, err := compiler.RPNConvert(sievedexp)
+			if foldCase {
+				flags |= compiler.FoldCase
+			}
+			if multiLine {
+				flags |= compiler.MultiLine
+			}
+			if freeSpacing {
+				flags |= compiler.FreeSpacing
+			}
+			prog, err := compiler.Parse(args[0], compiler.Options{Flags: flags, MaxRepeat: maxRepeat, FullMatch: fullMatch, Syntax: syn})
 			if err != nil {
-				log.Fatalln("cannot convert to RPN:", err)
+				log.Fatalln("cannot parse:", describe(err))
+			}
+			if printRPN {
+				fmt.Println(compiler.RPN(prog.Tree))
+				return
+			}
+			if printNFA || printDFA {
+				automaton, err := prog.NFA()
+				if err != nil {
+					log.Fatalln("cannot construct NFA:", err)
+				}
+				if printNFA {
+					fmt.Print(automaton)
+					return
+				}
+				deterministic, err := dfa.New(automaton, dfa.Config{})
+				if err != nil {
+					log.Fatalln("cannot construct DFA:", err)
+				}
+				fmt.Print(deterministic.Minimize())
+				return
 			}
-			rootgen, err := compiler.Compile(rpnexp)
+			rootgen, err := prog.Matcher()
 			if err != nil {
 				log.Fatalln("cannot produce matcher generator:", err)
 			}
@@ -55,6 +109,59 @@ purposes only.`,
 	}
 )
 
+// describe returns the message of a parse error, followed for a ParseError by
+// the line of the pattern where it was found, with a caret under that place
+// and tildes under the rest of the part at fault, and the tokens expected
+// there.
+func describe(err error) string {
+	var e *compiler.ParseError
+	if !errors.As(err, &e) {
+		return err.Error()
+	}
+	pattern := []rune(e.Pattern)
+	start, end := e.Pos, e.Pos
+	for start > 0 && pattern[start-1] != '\n' {
+		start--
+	}
+	for end < len(pattern) && pattern[end] != '\n' {
+		end++
+	}
+	var marks strings.Builder
+	for i := start; i <= end; i++ {
+		mark := ' '
+		switch {
+		case i == e.Pos:
+			mark = '^'
+		case e.Span.Start <= i && i < e.Span.End:
+			mark = '~'
+		case i < end && pattern[i] == '\t':
+			mark = '\t'
+		}
+		marks.WriteRune(mark)
+		// the ideographs and syllabaries of East Asia take two columns
+		if i < end && unicode.In(pattern[i], unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) {
+			if mark == '^' && (i < e.Span.Start || i+1 >= e.Span.End) {
+				mark = ' '
+			} else if mark == '^' {
+				mark = '~'
+			}
+			marks.WriteRune(mark)
+		}
+	}
+	desc := fmt.Sprintf("%s\n\t%s\n\t%s", e, string(pattern[start:end]), strings.TrimRight(marks.String(), " \t"))
+	if len(e.Expected) > 0 {
+		tokens := make([]string, len(e.Expected))
+		for i, token := range e.Expected {
+			tokens[i] = token
+			if !strings.HasPrefix(token, "<") {
+				tokens[i] = fmt.Sprintf("%q", token)
+			}
+		}
+		desc += "\nexpected " + strings.Join(tokens, " or ")
+	}
+	return desc
+}
+
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
@@ -64,4 +171,15 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&outputLang, "output-lang", "l", "golang", "name of output language")
+	rootCmd.PersistentFlags().BoolVarP(&dotNL, "dot-nl", "s", false, "let '.' match newline, as (?s) does")
+	rootCmd.PersistentFlags().BoolVarP(&foldCase, "ignore-case", "i", false, "match letters regardless of case, as (?i) does")
+	rootCmd.PersistentFlags().BoolVarP(&multiLine, "multi-line", "m", false, "let '^' and '$' match at the beginning and end of each line, as (?m) does")
+	rootCmd.PersistentFlags().BoolVarP(&freeSpacing, "free-spacing", "x", false, "ignore white space and '#' comments in the expression, as (?x) does")
+	rootCmd.PersistentFlags().BoolVarP(&unicodeClasses, "unicode-classes", "u", false, `let \d, \w and \s match beyond ASCII, by the Unicode tables`)
+	rootCmd.PersistentFlags().BoolVar(&fullMatch, "full-match", false, "match only the whole input, as if anchored by '^' and '$'")
+	rootCmd.PersistentFlags().StringVar(&syntax, "syntax", "native", "syntax of the expression: native, POSIX ere or bre, which match leftmost-longest, or go, as read by Go's regexp/syntax")
+	rootCmd.PersistentFlags().IntVar(&maxRepeat, "max-repeat", compiler.DefaultMaxRepeat, "largest count allowed in a repetition such as a{2,5}")
+	rootCmd.PersistentFlags().BoolVar(&printRPN, "rpn", false, "print the expression in reverse Polish notation instead of code")
+	rootCmd.PersistentFlags().BoolVar(&printNFA, "nfa", false, "print the Thompson NFA instead of code")
+	rootCmd.PersistentFlags().BoolVar(&printDFA, "dfa", false, "print the minimal DFA instead of code")
 }
@@ -0,0 +1,335 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Span is the range of runes [Start, End) of the pattern that a node was
+// parsed from.
+type Span struct {
+	Start, End int
+}
+
+// Pos returns the span itself, so that every node embedding a Span is a Node.
+func (s Span) Pos() Span {
+	return s
+}
+
+// A Node is a node of the syntax tree of a regular expression.
+type Node interface {
+	// Pos returns the span of the pattern the node was parsed from.
+	Pos() Span
+}
+
+// A Literal matches its rune.
+type Literal struct {
+	Span
+	Rune rune
+}
+
+// A Class matches any rune in Ranges, or if Negated any rune outside them.
+// Ranges holds sorted pairs of inclusive bounds, none overlapping.
+type Class struct {
+	Span
+	Ranges  []rune
+	Negated bool
+}
+
+// An Any matches any rune, except newline unless NL is set.
+type Any struct {
+	Span
+	NL bool
+}
+
+// An Anchor is a position in the input that an Assert matches at.
+type Anchor uint8
+
+const (
+	// BeginText is the beginning of the input, written '^'.
+	BeginText Anchor = iota
+	// EndText is the end of the input, written '$'.
+	EndText
+	// BeginLine is the beginning of the input or of a line, written '^'
+	// under MultiLine.
+	BeginLine
+	// EndLine is the end of the input or of a line, written '$' under
+	// MultiLine.
+	EndLine
+)
+
+// An Assert matches the empty string at its Anchor.
+type Assert struct {
+	Span
+	Anchor Anchor
+}
+
+// A Concat matches A followed by B.
+type Concat struct {
+	Span
+	A, B Node
+}
+
+// An Alternate matches either A or B, preferring A.
+type Alternate struct {
+	Span
+	A, B Node
+}
+
+// An Intersect matches the strings that both A and B match, as found by an
+// automaton: it prefers the longest match, whatever its closures prefer.
+// Groups within its operands do not capture.
+type Intersect struct {
+	Span
+	A, B Node
+}
+
+// A Complement matches the strings that Sub does not, as found by an
+// automaton: it prefers the longest match. Sub is complemented among all
+// strings, so that ~a matches newlines too. Groups within Sub do not capture.
+type Complement struct {
+	Span
+	Sub Node
+}
+
+// A Star matches zero or more occurrences of Sub, preferring as many as
+// possible, or if Lazy as few.
+type Star struct {
+	Span
+	Sub  Node
+	Lazy bool
+}
+
+// A Plus matches one or more occurrences of Sub, preferring as many as
+// possible, or if Lazy as few.
+type Plus struct {
+	Span
+	Sub  Node
+	Lazy bool
+}
+
+// A Quest matches zero or one occurrences of Sub, preferring one, or if Lazy
+// zero.
+type Quest struct {
+	Span
+	Sub  Node
+	Lazy bool
+}
+
+// A Repeat matches at least Min and at most Max occurrences of Sub, or if Max
+// is negative at least Min, preferring as many as possible, or if Lazy as few.
+type Repeat struct {
+	Span
+	Sub      Node
+	Min, Max int
+	Lazy     bool
+}
+
+// A Group is a parenthesized expression, which captures what Sub matches as
+// the submatch numbered Index, under Name if it has one. Capturing groups are
+// numbered from 1 in the order of their opening parentheses; non-capturing
+// groups have Index 0.
+type Group struct {
+	Span
+	Sub   Node
+	Index int
+	Name  string
+}
+
+// A Longest matches what Sub does, but prefers the longest of the matches
+// that begin at the same place, as POSIX does, and among those the one its
+// operators prefer. It may only be the root of a tree.
+type Longest struct {
+	Span
+	Sub Node
+}
+
+// An Empty matches the empty string: it denotes {ε}.
+type Empty struct {
+	Span
+}
+
+// A NoMatch matches nothing: it denotes the empty set ∅. No pattern parses to
+// one, but trees built by other means may hold them.
+type NoMatch struct {
+	Span
+}
+
+var anchorString = map[Anchor]string{
+	BeginText: "^", EndText: "$", BeginLine: "(?m:^)", EndLine: "(?m:$)",
+}
+
+// RPN returns the tree in the reverse Polish notation of RPNConvert, with
+// 'ε' for empty expressions and '∅' for those matching nothing. It is meant
+// for display.
+func RPN(root Node) string {
+	switch n := root.(type) {
+	case *Literal:
+		return literalString(n.Rune)
+	case *Class:
+		return classString(n)
+	case *Any:
+		return "."
+	case *Assert:
+		return anchorString[n.Anchor]
+	case *Concat:
+		return RPN(n.A) + RPN(n.B) + "⋅"
+	case *Alternate:
+		return RPN(n.A) + RPN(n.B) + "|"
+	case *Intersect:
+		return RPN(n.A) + RPN(n.B) + "&"
+	case *Complement:
+		return RPN(n.Sub) + "~"
+	case *Star:
+		return RPN(n.Sub) + "*" + lazyString(n.Lazy)
+	case *Plus:
+		return RPN(n.Sub) + "+" + lazyString(n.Lazy)
+	case *Quest:
+		return RPN(n.Sub) + "?" + lazyString(n.Lazy)
+	case *Repeat:
+		return RPN(n.Sub) + repeatString(n.Min, n.Max) + lazyString(n.Lazy)
+	case *Group:
+		return RPN(n.Sub)
+	case *Longest:
+		return RPN(n.Sub)
+	case *Empty:
+		return "ε"
+	case *NoMatch:
+		return "∅"
+	}
+	return "?"
+}
+
+// SubexpNames returns the names of the capturing groups of the tree, indexed
+// by their numbers. The name of the whole expression, at index 0, and of
+// unnamed groups are empty.
+func SubexpNames(root Node) []string {
+	names := []string{""}
+	var walk func(Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *Concat:
+			walk(n.A)
+			walk(n.B)
+		case *Alternate:
+			walk(n.A)
+			walk(n.B)
+		case *Intersect:
+			walk(n.A)
+			walk(n.B)
+		case *Complement:
+			walk(n.Sub)
+		case *Longest:
+			walk(n.Sub)
+		case *Star:
+			walk(n.Sub)
+		case *Plus:
+			walk(n.Sub)
+		case *Quest:
+			walk(n.Sub)
+		case *Repeat:
+			walk(n.Sub)
+		case *Group:
+			if n.Index > 0 {
+				for len(names) <= n.Index {
+					names = append(names, "")
+				}
+				names[n.Index] = n.Name
+			}
+			walk(n.Sub)
+		}
+	}
+	walk(root)
+	return names
+}
+
+// children returns the operands of the node, in the order they were written.
+func children(n Node) []Node {
+	switch n := n.(type) {
+	case *Concat:
+		return []Node{n.A, n.B}
+	case *Alternate:
+		return []Node{n.A, n.B}
+	case *Intersect:
+		return []Node{n.A, n.B}
+	case *Complement:
+		return []Node{n.Sub}
+	case *Star:
+		return []Node{n.Sub}
+	case *Plus:
+		return []Node{n.Sub}
+	case *Quest:
+		return []Node{n.Sub}
+	case *Repeat:
+		return []Node{n.Sub}
+	case *Group:
+		return []Node{n.Sub}
+	case *Longest:
+		return []Node{n.Sub}
+	}
+	return nil
+}
+
+// FullMatch returns the tree of an expression matching only the whole of an
+// input that root matches, by anchoring it at both ends.
+func FullMatch(root Node) Node {
+	span := root.Pos()
+	begin := &Assert{Span{span.Start, span.Start}, BeginText}
+	end := &Assert{Span{span.End, span.End}, EndText}
+	return &Concat{span, &Concat{span, begin, root}, end}
+}
+
+// repeatString returns the counts of a repetition in brace notation.
+func repeatString(min, max int) string {
+	switch {
+	case min == max:
+		return fmt.Sprintf("{%d}", min)
+	case max < 0:
+		return fmt.Sprintf("{%d,}", min)
+	}
+	return fmt.Sprintf("{%d,%d}", min, max)
+}
+
+// lazyString returns the '?' that follows the operator of a lazy closure.
+func lazyString(lazy bool) string {
+	if lazy {
+		return "?"
+	}
+	return ""
+}
+
+// classString returns the class in bracket notation.
+func classString(n *Class) string {
+	var b strings.Builder
+	b.WriteRune('[')
+	if n.Negated {
+		b.WriteRune('^')
+	}
+	for i := 0; i < len(n.Ranges); i += 2 {
+		b.WriteString(literalString(n.Ranges[i]))
+		if n.Ranges[i] != n.Ranges[i+1] {
+			b.WriteRune('-')
+			b.WriteString(literalString(n.Ranges[i+1]))
+		}
+	}
+	b.WriteRune(']')
+	return b.String()
+}
+
+// literalString returns the rune as it would be written in a pattern.
+func literalString(r rune) string {
+	if isSymbol(r) && unicode.IsPrint(r) {
+		return string(r)
+	}
+	for c, control := range controls {
+		if r == control {
+			return `\` + string(c)
+		}
+	}
+	if r < utf8.RuneSelf && unicode.IsPrint(r) {
+		return `\` + string(r)
+	}
+	return fmt.Sprintf(`\x{%x}`, r)
+}
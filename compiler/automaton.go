@@ -0,0 +1,62 @@
+package compiler
+
+import (
+	"thompson-regex/dfa"
+	"thompson-regex/nfa"
+)
+
+// automaton returns the minimal DFA accepting the strings that n matches in
+// full. Intersections and complements are found by the product and
+// complement automata of their operands; anything else is built from its NFA
+// by subset construction.
+func automaton(n Node) (*dfa.DFA, error) {
+	switch n := n.(type) {
+	case *Intersect:
+		a, err := automaton(n.A)
+		if err != nil {
+			return nil, err
+		}
+		b, err := automaton(n.B)
+		if err != nil {
+			return nil, err
+		}
+		d, err := dfa.Intersect(a, b, 0)
+		if err != nil {
+			return nil, err
+		}
+		return d.Minimize(), nil
+	case *Complement:
+		d, err := automaton(n.Sub)
+		if err != nil {
+			return nil, err
+		}
+		return d.Complement().Minimize(), nil
+	case *Group:
+		return automaton(n.Sub)
+	}
+	m, err := NFA(n)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dfa.New(m, dfa.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return d.Minimize(), nil
+}
+
+// transitions returns the transitions of each state of the automaton, one
+// for each state it may move to, and which states accept.
+func transitions(d *dfa.DFA) ([][]nfa.Transition, []bool) {
+	trans := make([][]nfa.Transition, d.NumStates())
+	for s, row := range d.Trans {
+		seen := map[int]bool{dfa.Dead: true}
+		for _, t := range row {
+			if !seen[t] {
+				seen[t] = true
+				trans[s] = append(trans[s], nfa.Transition{Ranges: d.Ranges(s, t), To: t})
+			}
+		}
+	}
+	return trans, d.Accepting
+}
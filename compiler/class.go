@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"sort"
+	"unicode"
+)
+
+// normalize sorts the ranges, given as pairs of inclusive bounds, and merges
+// those that overlap or abut.
+func normalize(ranges []rune) []rune {
+	pairs := make([][2]rune, 0, len(ranges)/2)
+	for i := 0; i < len(ranges); i += 2 {
+		pairs = append(pairs, [2]rune{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	var merged []rune
+	for _, p := range pairs {
+		if n := len(merged); n > 0 && p[0] <= merged[n-1]+1 {
+			if p[1] > merged[n-1] {
+				merged[n-1] = p[1]
+			}
+			continue
+		}
+		merged = append(merged, p[0], p[1])
+	}
+	return merged
+}
+
+// negate returns the normalized ranges of the runes outside the given
+// normalized ranges.
+func negate(ranges []rune) []rune {
+	var negated []rune
+	next := rune(0)
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > next {
+			negated = append(negated, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		negated = append(negated, next, unicode.MaxRune)
+	}
+	return negated
+}
+
+// shorthands maps the letters of the shorthand classes \d, \w and \s to their
+// ranges in ASCII, as in Go's regexp, and under UnicodeClasses: decimal
+// digits; letters, marks, decimal digits and connector punctuation; and white
+// space.
+var shorthands = map[rune]struct{ ascii, unicode []rune }{
+	'd': {[]rune{'0', '9'}, tableRanges(unicode.Nd)},
+	'w': {[]rune{'0', '9', 'A', 'Z', '_', '_', 'a', 'z'}, tableRanges(unicode.L, unicode.M, unicode.Nd, unicode.Pc)},
+	's': {[]rune{'\t', '\n', '\f', '\r', ' ', ' '}, tableRanges(unicode.White_Space)},
+}
+
+// posixClasses maps the names of the character classes of POSIX bracket
+// expressions, written [:name:], to their ranges in ASCII, as in Go's regexp.
+var posixClasses = map[string][]rune{
+	"alnum":  {'0', '9', 'A', 'Z', 'a', 'z'},
+	"alpha":  {'A', 'Z', 'a', 'z'},
+	"blank":  {'\t', '\t', ' ', ' '},
+	"cntrl":  {0, 0x1f, 0x7f, 0x7f},
+	"digit":  {'0', '9'},
+	"graph":  {'!', '~'},
+	"lower":  {'a', 'z'},
+	"print":  {' ', '~'},
+	"punct":  {'!', '/', ':', '@', '[', '`', '{', '~'},
+	"space":  {'\t', '\r', ' ', ' '},
+	"upper":  {'A', 'Z'},
+	"xdigit": {'0', '9', 'A', 'F', 'a', 'f'},
+}
+
+// tableRanges returns the normalized ranges of the runes in any of the
+// tables.
+func tableRanges(tables ...*unicode.RangeTable) []rune {
+	var ranges []rune
+	add := func(lo, hi, stride rune) {
+		if stride == 1 {
+			ranges = append(ranges, lo, hi)
+			return
+		}
+		for r := lo; r <= hi; r += stride {
+			ranges = append(ranges, r, r)
+		}
+	}
+	for _, t := range tables {
+		for _, r := range t.R16 {
+			add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+		}
+		for _, r := range t.R32 {
+			add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+		}
+	}
+	return normalize(ranges)
+}
+
+// properties returns the ranges of the Unicode general category or script
+// with the given name, or Any for every rune.
+func properties(name string) ([]rune, bool) {
+	if name == "Any" {
+		return []rune{0, unicode.MaxRune}, true
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return tableRanges(table), true
+	}
+	if table, ok := unicode.Scripts[name]; ok {
+		return tableRanges(table), true
+	}
+	return nil, false
+}
+
+// maxFold is the largest rune with case variants, as in regexp/syntax.
+const maxFold = 0x1e943
+
+// foldRanges returns the normalized ranges of the runes equivalent under
+// Unicode simple case folding to those in the given ranges.
+func foldRanges(ranges []rune) []rune {
+	folded := append([]rune(nil), ranges...)
+	for i := 0; i < len(ranges); i += 2 {
+		for r := ranges[i]; r <= ranges[i+1] && r <= maxFold; r++ {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				folded = append(folded, f, f)
+			}
+		}
+	}
+	return normalize(folded)
+}
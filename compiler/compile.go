@@ -2,7 +2,6 @@ package compiler
 
 import (
 	"fmt"
-	"log"
 
 	"thompson-regex/assembler"
 )
@@ -14,6 +13,82 @@ func (c RuneMatcher) Generate(codegens *assembler.CodeGenerators) (string, error
 	return codegens.Rune(rune(c))
 }
 
+// A ClassMatcher matches a single character in (or, if negated, outside) its
+// ranges, which are pairs of inclusive bounds.
+type ClassMatcher struct {
+	ranges  []rune
+	negated bool
+}
+
+func (m *ClassMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	return codegens.Class(m.ranges, m.negated)
+}
+
+// An AnyMatcher matches any single character, except newline unless nl is set.
+type AnyMatcher struct {
+	nl bool
+}
+
+func (m *AnyMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	return codegens.Any(m.nl)
+}
+
+// An EmptyMatcher matches the empty string.
+type EmptyMatcher struct{}
+
+func (EmptyMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	return codegens.Empty()
+}
+
+// A FailMatcher matches nothing.
+type FailMatcher struct{}
+
+func (FailMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	return codegens.Fail()
+}
+
+// An AutomatonMatcher matches the strings that a deterministic automaton
+// accepts from its start state, preferring the longest.
+type AutomatonMatcher struct {
+	start  int
+	states []assembler.AutomatonState
+}
+
+func (m *AutomatonMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	return codegens.Automaton(m.start, m.states)
+}
+
+// A LongestMatcher matches the longest of the matches of its matcher, and
+// among those the one it prefers.
+type LongestMatcher struct {
+	a assembler.MatcherGenerator
+}
+
+func (m *LongestMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	amc, err := m.a.Generate(codegens)
+	if err != nil {
+		return "", err
+	}
+	return codegens.Longest(amc)
+}
+
+// An AssertMatcher matches the empty string at its anchor.
+type AssertMatcher struct {
+	anchor Anchor
+}
+
+func (m *AssertMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	switch m.anchor {
+	case BeginText:
+		return codegens.Begin(false)
+	case EndText:
+		return codegens.End(false)
+	case BeginLine:
+		return codegens.Begin(true)
+	}
+	return codegens.End(true)
+}
+
 // An BinOpMatcher matches based on the provided matchers and binary operation.
 type BinOpMatcher struct {
 	a, b assembler.MatcherGenerator
@@ -35,10 +110,13 @@ func (m *BinOpMatcher) Generate(codegens *assembler.CodeGenerators) (string, err
 	}[m.op](amc, bmc)
 }
 
-// A ClosureMatcher matches based on the provided matcher and unary operation.
+// A ClosureMatcher matches at least min and at most max occurrences of its
+// matcher, or if max is negative at least min, preferring as many as possible,
+// or if lazy as few.
 type ClosureMatcher struct {
-	a  assembler.MatcherGenerator
-	op rune
+	a        assembler.MatcherGenerator
+	min, max int
+	lazy     bool
 }
 
 func (m *ClosureMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
@@ -46,44 +124,123 @@ func (m *ClosureMatcher) Generate(codegens *assembler.CodeGenerators) (string, e
 	if err != nil {
 		return "", err
 	}
-	return codegens.Closure(
-		amc,
-		map[rune]int{'*': 0, '+': 1}[m.op],
-	)
-}
-
-// Compile returns a string containing the Go source for a command-line program
-// that takes a string as its input and outputs the matches of the given regex.
-// The regex must be in reverse Polish notation.
-func Compile(regex string) (assembler.MatcherGenerator, error) {
-	stack := []assembler.MatcherGenerator{}
-	pop := func() assembler.MatcherGenerator {
-		mc := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-		return mc
+	return codegens.Closure(amc, m.min, m.max, m.lazy)
+}
+
+// A GroupMatcher matches its matcher, capturing what it matches as the
+// submatch numbered index, named name if it is not empty.
+type GroupMatcher struct {
+	a     assembler.MatcherGenerator
+	index int
+	name  string
+}
+
+func (m *GroupMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	amc, err := m.a.Generate(codegens)
+	if err != nil {
+		return "", err
 	}
-	push := func(m assembler.MatcherGenerator) {
-		stack = append(stack, m)
+	return codegens.Group(amc, m.index, m.name)
+}
+
+// An OptionalMatcher matches zero or one occurrences of its matcher,
+// preferring one, or if lazy zero.
+type OptionalMatcher struct {
+	a    assembler.MatcherGenerator
+	lazy bool
+}
+
+func (m *OptionalMatcher) Generate(codegens *assembler.CodeGenerators) (string, error) {
+	amc, err := m.a.Generate(codegens)
+	if err != nil {
+		return "", err
 	}
-	for _, c := range regex {
-		switch c {
-		case '+', '*':
-			push(&ClosureMatcher{pop(), c})
-			continue
-		case '|', '⋅':
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("cannot use %q with less than 2 elements", c)
-			}
-			// reversal for the sequential matcher case
-			a, b := pop(), pop()
-			push(&BinOpMatcher{b, a, c})
-			continue
-		default:
-			push(RuneMatcher(c))
+	return codegens.Optional(amc, m.lazy)
+}
+
+// Compile returns the matcher for the syntax tree of a regex, from which the
+// assemblers produce the source of a program that takes a string as its
+// input and outputs the matches of the regex.
+func Compile(root Node) (assembler.MatcherGenerator, error) {
+	switch n := root.(type) {
+	case *Literal:
+		return RuneMatcher(n.Rune), nil
+	case *Class:
+		return &ClassMatcher{n.Ranges, n.Negated}, nil
+	case *Any:
+		return &AnyMatcher{n.NL}, nil
+	case *Assert:
+		return &AssertMatcher{n.Anchor}, nil
+	case *Concat:
+		return compileBinOp(n.A, n.B, '⋅')
+	case *Alternate:
+		return compileBinOp(n.A, n.B, '|')
+	case *Intersect, *Complement:
+		return compileAutomaton(n)
+	case *Star:
+		return compileClosure(n.Sub, 0, -1, n.Lazy)
+	case *Plus:
+		return compileClosure(n.Sub, 1, -1, n.Lazy)
+	case *Repeat:
+		return compileClosure(n.Sub, n.Min, n.Max, n.Lazy)
+	case *Quest:
+		amc, err := Compile(n.Sub)
+		if err != nil {
+			return nil, err
+		}
+		return &OptionalMatcher{amc, n.Lazy}, nil
+	case *Group:
+		amc, err := Compile(n.Sub)
+		if err != nil || n.Index == 0 {
+			return amc, err
 		}
+		return &GroupMatcher{amc, n.Index, n.Name}, nil
+	case *Empty:
+		return EmptyMatcher{}, nil
+	case *NoMatch:
+		return FailMatcher{}, nil
+	case *Longest:
+		amc, err := Compile(n.Sub)
+		if err != nil {
+			return nil, err
+		}
+		return &LongestMatcher{amc}, nil
+	}
+	return nil, fmt.Errorf("unknown node %T", root)
+}
+
+func compileBinOp(a, b Node, op rune) (assembler.MatcherGenerator, error) {
+	amc, err := Compile(a)
+	if err != nil {
+		return nil, err
+	}
+	bmc, err := Compile(b)
+	if err != nil {
+		return nil, err
+	}
+	return &BinOpMatcher{amc, bmc, op}, nil
+}
+
+func compileClosure(a Node, min, max int, lazy bool) (assembler.MatcherGenerator, error) {
+	amc, err := Compile(a)
+	if err != nil {
+		return nil, err
+	}
+	return &ClosureMatcher{amc, min, max, lazy}, nil
+}
+
+func compileAutomaton(n Node) (assembler.MatcherGenerator, error) {
+	d, err := automaton(n)
+	if err != nil {
+		return nil, err
 	}
-	if len(stack) != 1 {
-		log.Fatalln("stack length not 1")
+	trans, accepting := transitions(d)
+	states := make([]assembler.AutomatonState, len(trans))
+	for s, ts := range trans {
+		states[s].Accepting = accepting[s]
+		for _, t := range ts {
+			states[s].Edges = append(states[s].Edges, assembler.Edge{Ranges: t.Ranges, To: t.To})
+		}
 	}
-	return stack[0], nil
+	return &AutomatonMatcher{d.Start, states}, nil
 }
@@ -0,0 +1,95 @@
+package compiler
+
+import "fmt"
+
+// An ErrorCode classifies a ParseError. The codes are stable, so that
+// programs may check for them rather than for the wording of the messages.
+type ErrorCode string
+
+const (
+	// ErrMissingParen means a group is not closed.
+	ErrMissingParen ErrorCode = "missing closing )"
+	// ErrUnexpectedParen means a ')' closes no group.
+	ErrUnexpectedParen ErrorCode = "unexpected )"
+	// ErrMissingBracket means a bracket class is not closed.
+	ErrMissingBracket ErrorCode = "missing closing ]"
+	// ErrEmptyClass means a bracket class has no items.
+	ErrEmptyClass ErrorCode = "empty character class"
+	// ErrInvalidRange means a class range is out of order or has a class at an end.
+	ErrInvalidRange ErrorCode = "invalid character class range"
+	// ErrInvalidClassName means a Unicode class is malformed or unknown.
+	ErrInvalidClassName ErrorCode = "invalid Unicode class"
+	// ErrInvalidBracketClass means a POSIX class or collating element in a
+	// bracket class is malformed or unknown.
+	ErrInvalidBracketClass ErrorCode = "invalid character class"
+	// ErrMissingRepeatArg means a closure operator has nothing to repeat.
+	ErrMissingRepeatArg ErrorCode = "missing argument to repetition operator"
+	// ErrNestedRepeat means a closure operator follows another.
+	ErrNestedRepeat ErrorCode = "invalid nested repetition operator"
+	// ErrInvalidRepeat means a repetition count is missing, malformed or out of order.
+	ErrInvalidRepeat ErrorCode = "invalid repetition"
+	// ErrRepeatSize means a repetition counts more occurrences than the limit allows.
+	ErrRepeatSize ErrorCode = "repetition count too large"
+	// ErrInvalidSymbol means a character may not appear where it does.
+	ErrInvalidSymbol ErrorCode = "invalid symbol"
+	// ErrTrailingBackslash means the pattern ends in a backslash.
+	ErrTrailingBackslash ErrorCode = "trailing backslash"
+	// ErrInvalidEscape means an escape sequence is unknown or malformed.
+	ErrInvalidEscape ErrorCode = "invalid escape sequence"
+	// ErrInvalidGroup means a group begins with an unknown '(?' form.
+	ErrInvalidGroup ErrorCode = "invalid group syntax"
+	// ErrInvalidGroupName means a group name is empty, unclosed or begins with a digit.
+	ErrInvalidGroupName ErrorCode = "invalid group name"
+	// ErrDuplicateGroupName means two groups have the same name.
+	ErrDuplicateGroupName ErrorCode = "duplicate group name"
+	// ErrInvalidFlags means a flag group is malformed or names an unknown flag.
+	ErrInvalidFlags ErrorCode = "invalid flags"
+	// ErrMissingOperand means '&' or '~' lacks an operand.
+	ErrMissingOperand ErrorCode = "missing operand"
+	// ErrInvalidOperand means an operand of '&' or '~' holds an assertion.
+	ErrInvalidOperand ErrorCode = "invalid operand"
+	// ErrUnsupported means the construct is valid in its syntax but cannot be compiled.
+	ErrUnsupported ErrorCode = "unsupported construct"
+)
+
+// A ParseError reports why a pattern was rejected, and where.
+//
+// Pos is the rune offset in Pattern at which the error was found, and Offset
+// the byte offset of the same place. Span is the part of the pattern at fault,
+// such as the whole of a group that is not closed, and holds Pos or ends at
+// it. Expected lists the tokens that could have come at Pos instead, if the
+// error is that something is missing: the characters themselves, or in angle
+// brackets the names of productions in the grammar of ParseTree, such as
+// <count> and <hex>.
+type ParseError struct {
+	Code     ErrorCode
+	Msg      string
+	Pattern  string
+	Pos      int
+	Offset   int
+	Span     Span
+	Expected []string
+}
+
+func (e *ParseError) Error() string {
+	return e.Msg
+}
+
+// newParseError returns the error found at pos in the pattern, concerning the
+// span, with the message of the format.
+func newParseError(pattern []rune, code ErrorCode, span Span, pos int, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Code:    code,
+		Msg:     fmt.Sprintf(format, args...),
+		Pattern: string(pattern),
+		Pos:     pos,
+		Offset:  len(string(pattern[:pos])),
+		Span:    span,
+	}
+}
+
+// expecting sets the tokens that could have come at the position of the error.
+func (e *ParseError) expecting(tokens ...string) *ParseError {
+	e.Expected = tokens
+	return e
+}
@@ -0,0 +1,335 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An ImportError reports a construct of a tree of regexp/syntax that has no
+// node in the syntax tree of this package.
+type ImportError struct {
+	// Op is the operator of the construct, and Expr the construct as
+	// regexp/syntax writes it.
+	Op   syntax.Op
+	Expr string
+}
+
+// unsupportedOps names the operators of regexp/syntax that Import rejects,
+// and gives the escapes that write them.
+var unsupportedOps = map[syntax.Op]struct{ name, escape string }{
+	syntax.OpWordBoundary:   {"word boundary", `\b`},
+	syntax.OpNoWordBoundary: {"non-word boundary", `\B`},
+}
+
+func (e *ImportError) Error() string {
+	if e.Op == syntax.OpCapture {
+		return fmt.Sprintf("group %s has a name that begins with a digit", e.Expr)
+	}
+	if op, ok := unsupportedOps[e.Op]; ok {
+		return fmt.Sprintf("%s %s is not supported", op.name, e.Expr)
+	}
+	return fmt.Sprintf("operator %v in %s is not supported", e.Op, e.Expr)
+}
+
+// Import returns the syntax tree of a regular expression parsed by
+// regexp/syntax. Its literals, classes, wildcards, anchors of lines and text,
+// captures, closures, repetitions, concatenations and alternations become the
+// nodes that stand for them, and OpNoMatch and OpEmptyMatch a NoMatch and an
+// Empty; the boundaries of words, which the automata have no way to check, are
+// reported by an ImportError, as are groups with names that begin with a
+// digit, which ParseTree rejects. The nodes have empty spans.
+func Import(re *syntax.Regexp) (Node, error) {
+	lazy := re.Flags&syntax.NonGreedy != 0
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return &NoMatch{}, nil
+	case syntax.OpEmptyMatch:
+		return &Empty{}, nil
+	case syntax.OpLiteral:
+		var n Node
+		for _, r := range re.Rune {
+			var m Node = &Literal{Rune: r}
+			if re.Flags&syntax.FoldCase != 0 && unicode.SimpleFold(r) != r {
+				m = &Class{Ranges: foldRanges([]rune{r, r})}
+			}
+			n = join(n, m)
+		}
+		if n == nil {
+			return &Empty{}, nil
+		}
+		return n, nil
+	case syntax.OpCharClass:
+		return &Class{Ranges: append([]rune(nil), re.Rune...)}, nil
+	case syntax.OpAnyCharNotNL:
+		return &Any{}, nil
+	case syntax.OpAnyChar:
+		return &Any{NL: true}, nil
+	case syntax.OpBeginLine:
+		return &Assert{Anchor: BeginLine}, nil
+	case syntax.OpEndLine:
+		return &Assert{Anchor: EndLine}, nil
+	case syntax.OpBeginText:
+		return &Assert{Anchor: BeginText}, nil
+	case syntax.OpEndText:
+		return &Assert{Anchor: EndText}, nil
+	case syntax.OpCapture:
+		// names are printed alongside the numbers of unnamed groups
+		if re.Name != "" && '0' <= re.Name[0] && re.Name[0] <= '9' {
+			return nil, &ImportError{re.Op, re.String()}
+		}
+		sub, err := Import(re.Sub[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Group{Sub: sub, Index: re.Cap, Name: re.Name}, nil
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		sub, err := Import(re.Sub[0])
+		if err != nil {
+			return nil, err
+		}
+		switch re.Op {
+		case syntax.OpStar:
+			return &Star{Sub: sub, Lazy: lazy}, nil
+		case syntax.OpPlus:
+			return &Plus{Sub: sub, Lazy: lazy}, nil
+		case syntax.OpQuest:
+			return &Quest{Sub: sub, Lazy: lazy}, nil
+		}
+		return &Repeat{Sub: sub, Min: re.Min, Max: re.Max, Lazy: lazy}, nil
+	case syntax.OpConcat, syntax.OpAlternate:
+		// an empty concatenation matches the empty string, and an empty
+		// alternation nothing
+		var n Node
+		for _, sub := range re.Sub {
+			m, err := Import(sub)
+			switch {
+			case err != nil:
+				return nil, err
+			case n == nil:
+				n = m
+			case re.Op == syntax.OpConcat:
+				n = &Concat{A: n, B: m}
+			default:
+				n = &Alternate{A: n, B: m}
+			}
+		}
+		if n == nil && re.Op == syntax.OpConcat {
+			return &Empty{}, nil
+		}
+		if n == nil {
+			return &NoMatch{}, nil
+		}
+		return n, nil
+	}
+	return nil, &ImportError{re.Op, re.String()}
+}
+
+// goErrorCodes maps the error codes of regexp/syntax to those of this package.
+// A code missing from it has no equivalent here, and becomes ErrUnsupported;
+// ErrInvalidRepeatSize becomes ErrInvalidRepeat instead if the counts are out
+// of order rather than too large, and ErrInvalidCharRange, which regexp/syntax
+// also reports for unknown class names, ErrInvalidBracketClass or
+// ErrInvalidClassName for those.
+var goErrorCodes = map[syntax.ErrorCode]ErrorCode{
+	syntax.ErrInvalidCharClass:      ErrInvalidBracketClass,
+	syntax.ErrInvalidCharRange:      ErrInvalidRange,
+	syntax.ErrInvalidEscape:         ErrInvalidEscape,
+	syntax.ErrInvalidNamedCapture:   ErrInvalidGroupName,
+	syntax.ErrInvalidPerlOp:         ErrInvalidGroup,
+	syntax.ErrInvalidRepeatOp:       ErrNestedRepeat,
+	syntax.ErrInvalidRepeatSize:     ErrRepeatSize,
+	syntax.ErrInvalidUTF8:           ErrInvalidSymbol,
+	syntax.ErrMissingBracket:        ErrMissingBracket,
+	syntax.ErrMissingParen:          ErrMissingParen,
+	syntax.ErrMissingRepeatArgument: ErrMissingRepeatArg,
+	syntax.ErrTrailingBackslash:     ErrTrailingBackslash,
+	syntax.ErrUnexpectedParen:       ErrUnexpectedParen,
+	// ErrLarge, which is not declared before Go 1.20
+	"expression too large": ErrRepeatSize,
+}
+
+// parseGo parses a pattern in the syntax of Go's regexp package with
+// regexp/syntax, and returns the tree that Import makes of it once simplified.
+// The flags FoldCase, DotNL and MultiLine are passed on to regexp/syntax;
+// UnicodeClasses and FreeSpacing have no counterpart there, and are ignored.
+// The errors of regexp/syntax are given the codes of this package that share
+// their meaning. Whatever maxRepeat allows, regexp/syntax rejects counts above
+// 1000.
+func parseGo(regex string, flags Flags, maxRepeat int) (Node, error) {
+	input := []rune(regex)
+	goFlags := syntax.Perl
+	if flags&FoldCase != 0 {
+		goFlags |= syntax.FoldCase
+	}
+	if flags&DotNL != 0 {
+		goFlags |= syntax.DotNL
+	}
+	if flags&MultiLine != 0 {
+		goFlags &^= syntax.OneLine
+	}
+	re, err := syntax.Parse(regex, goFlags)
+	var e *syntax.Error
+	if errors.As(err, &e) {
+		return nil, goParseError(input, e)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if goRepeatSize(re) > maxRepeat {
+		return nil, newParseError(input, ErrRepeatSize, Span{0, len(input)}, 0, "repetitions exceed %d occurrences", maxRepeat)
+	}
+	n, err := Import(re.Simplify())
+	var ie *ImportError
+	if errors.As(err, &ie) && ie.Op == syntax.OpCapture {
+		// the group is written (?P<name>, or from Go 1.22 also (?<name>
+		name := strings.TrimPrefix(ie.Expr, "(?P<")
+		name = name[:strings.IndexByte(name, '>')]
+		pos := 0
+		for _, open := range []string{"(?P<", "(?<"} {
+			if i := strings.Index(regex, open+name+">"); i >= 0 {
+				pos = utf8.RuneCountInString(regex[:i+len(open)])
+				break
+			}
+		}
+		span := Span{pos, pos + utf8.RuneCountInString(name)}
+		return nil, newParseError(input, ErrInvalidGroupName, span, pos, "group name %q at position %d begins with a digit", name, pos)
+	}
+	if ie != nil {
+		pos, escape := 0, unsupportedOps[ie.Op].escape
+		for i := 0; escape != "" && i+1 < len(input); i++ {
+			if string(input[i:i+2]) == escape {
+				pos = i
+				break
+			}
+			if input[i] == '\\' {
+				i++
+			}
+		}
+		return nil, newParseError(input, ErrUnsupported, Span{pos, pos + len(escape)}, pos, "%s at position %d", ie, pos)
+	}
+	return n, err
+}
+
+// goParseError returns the ParseError for an error of regexp/syntax in the
+// pattern.
+func goParseError(input []rune, e *syntax.Error) *ParseError {
+	code, ok := goErrorCodes[e.Code]
+	if !ok {
+		code = ErrUnsupported
+	}
+	switch {
+	case e.Code == syntax.ErrInvalidRepeatSize && !goRepeatOrder(e.Expr):
+		code = ErrInvalidRepeat
+	case e.Code == syntax.ErrInvalidCharRange && strings.HasPrefix(e.Expr, "[:"):
+		code = ErrInvalidBracketClass
+	case e.Code == syntax.ErrInvalidCharRange && (strings.HasPrefix(e.Expr, `\p`) || strings.HasPrefix(e.Expr, `\P`)):
+		code = ErrInvalidClassName
+	}
+	switch e.Code {
+	case syntax.ErrMissingParen:
+		// the error holds the whole of the pattern
+		start := goUnclosedParen(input)
+		if start < 0 {
+			start = 0
+		}
+		return newParseError(input, code, Span{start, len(input)}, len(input), "bracket opened at position %d not closed", start).expecting(")")
+	case syntax.ErrUnexpectedParen:
+		pos := goUnclosedParen(input)
+		if pos < 0 {
+			pos = 0
+		}
+		return newParseError(input, code, Span{pos, pos + 1}, pos, "unmatched ')' at position %d", pos)
+	}
+	// the error holds the part of the pattern at fault, but not where
+	regex := string(input)
+	start := 0
+	if i := strings.Index(regex, e.Expr); i >= 0 {
+		start = utf8.RuneCountInString(regex[:i])
+	}
+	span := Span{start, start + utf8.RuneCountInString(e.Expr)}
+	return newParseError(input, code, span, start, "%s: %q at position %d", e.Code, e.Expr, start)
+}
+
+// goRepeatOrder reports whether the counts of a repetition {m}, {m,} or
+// {m,n} in Go's syntax are in order, so that it is only too large.
+func goRepeatOrder(expr string) bool {
+	if i := strings.LastIndexByte(expr, '{'); i >= 0 {
+		expr = expr[i:]
+	}
+	var min, max int
+	if n, _ := fmt.Sscanf(expr, "{%d,%d}", &min, &max); n == 2 {
+		return min <= max
+	}
+	return true
+}
+
+// goUnclosedParen returns the position of the first ')' in a pattern in Go's
+// syntax that closes no group, or failing that of the innermost '(' left
+// unclosed, or -1 if the parentheses balance. Escaped parentheses, those
+// quoted by \Q...\E and those in bracket classes are skipped.
+func goUnclosedParen(input []rune) int {
+	var opens []int
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 < len(input) && input[i+1] == 'Q' {
+				for i += 2; i < len(input) && !(input[i] == '\\' && i+1 < len(input) && input[i+1] == 'E'); i++ {
+				}
+			}
+			i++
+		case '[':
+			i++
+			if i < len(input) && input[i] == '^' {
+				i++
+			}
+			// a ']' first in the class stands for itself
+			if i < len(input) && input[i] == ']' {
+				i++
+			}
+			for ; i < len(input) && input[i] != ']'; i++ {
+				switch {
+				case input[i] == '\\':
+					i++
+				case input[i] == '[' && i+1 < len(input) && input[i+1] == ':':
+					for i += 2; i+1 < len(input) && !(input[i] == ':' && input[i+1] == ']'); i++ {
+					}
+					i++
+				}
+			}
+		case '(':
+			opens = append(opens, i)
+		case ')':
+			if len(opens) == 0 {
+				return i
+			}
+			opens = opens[:len(opens)-1]
+		}
+	}
+	if len(opens) == 0 {
+		return -1
+	}
+	return opens[len(opens)-1]
+}
+
+// goRepeatSize is repeatSize for the trees of regexp/syntax.
+func goRepeatSize(re *syntax.Regexp) int {
+	size := 1
+	for _, sub := range re.Sub {
+		size = maxInt(size, goRepeatSize(sub))
+	}
+	if re.Op != syntax.OpRepeat {
+		return size
+	}
+	count := re.Max
+	if count < 0 {
+		count = re.Min
+	}
+	if count == 0 {
+		return size
+	}
+	return count * size
+}
@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"errors"
+	"regexp/syntax"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	cases := map[string]string{
+		"a(b|c)*d":             "a[b-c]*⋅d⋅",
+		`(?i)k\x{e9}`:          "[KkK][Éé]⋅",
+		`\Qa.b\E+`:             `a\.⋅b+⋅`,
+		"[[:^alpha:]]":         "[\\x{0}-\\@\\[-\\`\\{-\\x{10ffff}]",
+		"(?U)a*b+?":            "a*?b+⋅",
+		"(?s:.)x|.y":           ".x⋅.y⋅|",
+		`\Aa$(?m:^)`:           "^a⋅$⋅(?m:^)⋅",
+		"x{2,3}":               "xx⋅x?⋅",
+		"a{0}":                 "ε",
+		"[^\\x00-\\x{10FFFF}]": "[]",
+		"(?P<n>a)|()":          "aε|",
+	}
+	for r, rpn := range cases {
+		re, err := syntax.Parse(r, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := Import(re.Simplify())
+		if err != nil {
+			t.Fatalf("%q: %v", r, err)
+		}
+		if out := RPN(n); rpn != out {
+			t.Fatalf("%q: expected %q got %q", r, rpn, out)
+		}
+	}
+	re, err := syntax.Parse("(?s:.).", syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Import(re)
+	if c, ok := n.(*Concat); err != nil || !ok || !c.A.(*Any).NL || c.B.(*Any).NL {
+		t.Fatalf("expected the first wildcard alone to match newline got %#v", n)
+	}
+	prog, err := Parse("(?P<n>a)|(b)", Options{Syntax: Go})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := prog.SubexpNames(); len(names) != 3 || names[1] != "n" {
+		t.Fatalf("expected the names of the groups got %q", names)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	cases := map[string]struct {
+		code ErrorCode
+		pos  int
+	}{
+		`a\bc`:          {ErrUnsupported, 1},
+		`\\b(\B)`:       {ErrUnsupported, 4},
+		"a(b":           {ErrMissingParen, 3},
+		"ab)":           {ErrUnexpectedParen, 2},
+		`\(()[)]\Q)\E)`: {ErrUnexpectedParen, 12},
+		`x\q`:           {ErrInvalidEscape, 1},
+		"a**":           {ErrNestedRepeat, 1},
+		"a{2,1}":        {ErrInvalidRepeat, 1},
+		"a{1001}":       {ErrRepeatSize, 1},
+		"(a{10}){2}":    {ErrRepeatSize, 0},
+		"[[:foo:]]":     {ErrInvalidBracketClass, 1},
+		"(?P<2>a)(b)":   {ErrInvalidGroupName, 4},
+		"(?P<1x>a)":     {ErrInvalidGroupName, 4},
+	}
+	for r, c := range cases {
+		var e *ParseError
+		if _, err := Parse(r, Options{Syntax: Go, MaxRepeat: 15}); !errors.As(err, &e) {
+			t.Fatalf("%q: expected a ParseError got %v", r, err)
+		}
+		if e.Code != c.code || e.Pos != c.pos {
+			t.Fatalf("%q: expected %q at %d got %q at %d: %s", r, c.code, c.pos, e.Code, e.Pos, e)
+		}
+	}
+	re, err := syntax.Parse(`a\b`, syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *ImportError
+	if _, err := Import(re); !errors.As(err, &e) || e.Op != syntax.OpWordBoundary {
+		t.Fatalf("expected an ImportError for the word boundary got %v", err)
+	}
+	re, err = syntax.Parse("(?P<2>a)", syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(re); !errors.As(err, &e) || e.Op != syntax.OpCapture {
+		t.Fatalf("expected an ImportError for the group name got %v", err)
+	}
+}
@@ -0,0 +1,861 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isSymbol reports whether c stands for itself: any letter or digit of ASCII,
+// and any character beyond it.
+func isSymbol(c rune) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= utf8.RuneSelf
+}
+
+// Flags alter the meaning of patterns.
+type Flags uint
+
+const (
+	// DotNL lets '.' match newline.
+	DotNL Flags = 1 << iota
+	// UnicodeClasses extends the shorthand classes \d, \w and \s, and their
+	// complements, beyond ASCII to the Unicode decimal digits, the letters,
+	// marks, decimal digits and connector punctuation, and the white space.
+	UnicodeClasses
+	// FoldCase matches letters regardless of case, by Unicode simple case
+	// folding: each literal and class is widened to the case variants of the
+	// characters it matches.
+	FoldCase
+	// MultiLine lets '^' and '$' match at the beginning and end of each line
+	// as well as of the input.
+	MultiLine
+	// FreeSpacing ignores white space, and comments from '#' to the end of the
+	// line, outside classes and escapes, so that long patterns may be laid
+	// out and annotated.
+	FreeSpacing
+)
+
+// flagLetters maps the letters of flag groups to the flags they stand for.
+var flagLetters = map[rune]Flags{'i': FoldCase, 'm': MultiLine, 's': DotNL, 'x': FreeSpacing}
+
+// flagNames returns the letters of flag groups in order.
+func flagNames() []string {
+	var names []string
+	for c := range flagLetters {
+		names = append(names, string(c))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultMaxRepeat is the largest number of occurrences a repetition may count
+// unless Options say otherwise, taking into account the repetitions nested
+// within it, so that patterns such as a{100000} or (a{1000}){1000} can't blow
+// up the automata built from them.
+const DefaultMaxRepeat = 1000
+
+func isClosure(c rune) bool {
+	return c == '*' || c == '+' || c == '?' || c == '{'
+}
+
+// A parser builds the syntax tree of a pattern by recursive descent.
+type parser struct {
+	input []rune
+	pos   int
+	flags Flags
+	// maxRepeat is the largest number of occurrences a repetition may count.
+	maxRepeat int
+	// groups counts the capturing groups opened so far, and names holds
+	// the names given to them.
+	groups int
+	names  map[string]bool
+}
+
+func (p *parser) end() bool {
+	return p.pos == len(p.input)
+}
+
+func (p *parser) peek() rune {
+	return p.input[p.pos]
+}
+
+// skip passes over white space and comments under FreeSpacing.
+func (p *parser) skip() {
+	for p.flags&FreeSpacing != 0 && !p.end() {
+		switch c := p.peek(); {
+		case c == '#':
+			for !p.end() && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == ' ' || ('\t' <= c && c <= '\r'):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// expr → inter { '|' inter }
+func (p *parser) expr() (Node, error) {
+	n, err := p.inter()
+	if err != nil {
+		return nil, err
+	}
+	for !p.end() && p.peek() == '|' {
+		p.pos++
+		m, err := p.inter()
+		if err != nil {
+			return nil, err
+		}
+		n = &Alternate{Span{n.Pos().Start, m.Pos().End}, n, m}
+	}
+	return n, nil
+}
+
+// inter → concat { '&' concat }
+func (p *parser) inter() (Node, error) {
+	n, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	for !p.end() && p.peek() == '&' {
+		if err := p.operand(n); err != nil {
+			return nil, err
+		}
+		p.pos++
+		m, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.operand(m); err != nil {
+			return nil, err
+		}
+		n = &Intersect{Span{n.Pos().Start, m.Pos().End}, n, m}
+	}
+	return n, nil
+}
+
+// operand checks that n may be an operand of '&' or '~', which are found by
+// automata that cannot check assertions nor capture, and makes the groups in
+// it non-capturing.
+func (p *parser) operand(n Node) error {
+	switch n := n.(type) {
+	case *Assert:
+		return p.errorf(ErrInvalidOperand, n.Span, n.Start, "assertion at position %d in an operand of '&' or '~'", n.Start)
+	case *Group:
+		n.Index, n.Name = 0, ""
+	}
+	for _, m := range children(n) {
+		if err := p.operand(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// concat → closed { closed } | ε
+func (p *parser) concat() (Node, error) {
+	start := p.pos
+	var n Node
+	for p.skip(); !p.end() && p.peek() != '|' && p.peek() != '&' && p.peek() != ')'; p.skip() {
+		m, err := p.closed()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m == nil:
+			// a flag group matches nothing
+		case n == nil:
+			n = m
+		default:
+			n = &Concat{Span{n.Pos().Start, m.Pos().End}, n, m}
+		}
+	}
+	if n == nil {
+		return &Empty{Span{start, start}}, nil
+	}
+	return n, nil
+}
+
+// closed → '~' closed | basic ( '*' | '+' | '?' | repeat ) [ '?' ] | basic
+func (p *parser) closed() (Node, error) {
+	if p.peek() == '~' {
+		return p.complement()
+	}
+	n, err := p.basic()
+	if err != nil || n == nil {
+		return n, err
+	}
+	p.skip()
+	if p.end() || !isClosure(p.peek()) {
+		return n, nil
+	}
+	c := p.peek()
+	min, max := 0, -1
+	if c == '{' {
+		start := p.pos
+		if min, max, err = p.repeat("{", "}"); err != nil {
+			return nil, err
+		}
+		if repeatSize(&Repeat{Sub: n, Min: min, Max: max}) > p.maxRepeat {
+			return nil, p.errorf(ErrRepeatSize, Span{start, p.pos}, start, "repetition at position %d exceeds %d occurrences", start, p.maxRepeat)
+		}
+	} else {
+		p.pos++
+	}
+	// a '?' after the operator makes it lazy
+	lazy := !p.end() && p.peek() == '?'
+	if lazy {
+		p.pos++
+	}
+	if p.skip(); !p.end() && isClosure(p.peek()) {
+		return nil, p.errorf(ErrNestedRepeat, Span{p.pos, p.pos + 1}, p.pos, "double closures not allowed at position %d", p.pos)
+	}
+	span := Span{n.Pos().Start, p.pos}
+	switch c {
+	case '*':
+		return &Star{span, n, lazy}, nil
+	case '+':
+		return &Plus{span, n, lazy}, nil
+	case '?':
+		return &Quest{span, n, lazy}, nil
+	}
+	return &Repeat{span, n, min, max, lazy}, nil
+}
+
+// complement → '~' closed
+func (p *parser) complement() (Node, error) {
+	start := p.pos
+	p.pos++
+	var n Node
+	var err error
+	// a flag group before the operand is no operand itself
+	for p.skip(); n == nil && !p.end() && p.peek() != '|' && p.peek() != '&' && p.peek() != ')'; p.skip() {
+		if n, err = p.closed(); err != nil {
+			return nil, err
+		}
+	}
+	if n == nil {
+		return nil, p.errorf(ErrMissingOperand, Span{start, p.pos}, p.pos, "missing argument to '~' at position %d", start)
+	}
+	if err := p.operand(n); err != nil {
+		return nil, err
+	}
+	return &Complement{Span{start, n.Pos().End}, n}, nil
+}
+
+// repeat → open count close | open count ',' close | open count ',' count close
+// where open and close are '{' and '}', or in BRE '\{' and '\}'.
+func (p *parser) repeat(open, close string) (min, max int, err error) {
+	start := p.pos
+	p.pos += len(open)
+	if min, err = p.count(); err != nil {
+		return 0, 0, err
+	}
+	max = min
+	expected := []string{",", close}
+	if !p.end() && p.peek() == ',' {
+		p.pos++
+		max, expected = -1, []string{"<count>", close}
+		if !p.end() && !p.lookingAt(close) {
+			if max, err = p.count(); err != nil {
+				return 0, 0, err
+			}
+			if max < min {
+				return 0, 0, p.errorf(ErrInvalidRepeat, Span{start, p.pos}, start, "repetition at position %d has maximum below minimum", start)
+			}
+		}
+	}
+	if !p.lookingAt(close) {
+		return 0, 0, p.errorf(ErrInvalidRepeat, Span{start, p.pos}, p.pos, "repetition at position %d not closed", start).expecting(expected...)
+	}
+	p.pos += len(close)
+	return min, max, nil
+}
+
+// count reads a decimal number, saturating just above maxRepeat.
+func (p *parser) count() (int, error) {
+	start := p.pos
+	n := 0
+	for ; !p.end() && '0' <= p.peek() && p.peek() <= '9'; p.pos++ {
+		if n = 10*n + int(p.peek()-'0'); n > p.maxRepeat {
+			n = p.maxRepeat + 1
+		}
+	}
+	if p.pos == start {
+		return 0, p.errorf(ErrInvalidRepeat, Span{start, start}, start, "missing repetition count at position %d", start).expecting("<count>")
+	}
+	return n, nil
+}
+
+// repeatSize returns the largest number of occurrences that the repetitions
+// nested in n multiply to, counting unbounded ones by their minimum.
+func repeatSize(n Node) int {
+	switch n := n.(type) {
+	case *Repeat:
+		count := n.Max
+		if count < 0 {
+			count = n.Min
+		}
+		if count == 0 {
+			return repeatSize(n.Sub)
+		}
+		return count * repeatSize(n.Sub)
+	case *Concat:
+		return maxInt(repeatSize(n.A), repeatSize(n.B))
+	case *Alternate:
+		return maxInt(repeatSize(n.A), repeatSize(n.B))
+	case *Intersect:
+		return maxInt(repeatSize(n.A), repeatSize(n.B))
+	case *Complement:
+		return repeatSize(n.Sub)
+	case *Star:
+		return repeatSize(n.Sub)
+	case *Plus:
+		return repeatSize(n.Sub)
+	case *Quest:
+		return repeatSize(n.Sub)
+	case *Group:
+		return repeatSize(n.Sub)
+	}
+	return 1
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// basic → group | '.' | '^' | '$' | class | shorthand | escape | symbol
+func (p *parser) basic() (Node, error) {
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '(':
+		return p.group()
+	case p.atShorthand():
+		ranges, negated, err := p.shorthand()
+		if err != nil {
+			return nil, err
+		}
+		return &Class{Span{start, p.pos}, p.fold(ranges), negated}, nil
+	case c == '.':
+		p.pos++
+		return &Any{Span{start, p.pos}, p.flags&DotNL != 0}, nil
+	case c == '^' || c == '$':
+		p.pos++
+		return p.anchor(Span{start, p.pos}, c), nil
+	case c == '[':
+		return p.class()
+	case c == '\\':
+		r, err := p.escape()
+		if err != nil {
+			return nil, err
+		}
+		return p.literal(Span{start, p.pos}, r), nil
+	case isClosure(c):
+		return nil, p.errorf(ErrMissingRepeatArg, Span{start, start + 1}, start, "missing argument to %q at position %d", c, start)
+	case isSymbol(c):
+		p.pos++
+		return p.literal(Span{start, p.pos}, c), nil
+	default:
+		return nil, p.errorf(ErrInvalidSymbol, Span{start, start + 1}, start, "%q at position %d is not an allowed symbol", c, start)
+	}
+}
+
+// group → '(' expr ')' | '(?:' expr ')' | '(?P<' name '>' expr ')'
+// group → '(?' flags ':' expr ')' | '(?' flags ')'
+// A group of flags alone sets them until the end of the enclosing group, and
+// has no node.
+func (p *parser) group() (Node, error) {
+	start := p.pos
+	p.pos++
+	capturing, name := true, ""
+	flags := p.flags
+	if p.lookingAt("?") {
+		switch {
+		case p.lookingAt("?:"):
+			p.pos += 2
+			capturing = false
+		case p.lookingAt("?P<"):
+			p.pos += 3
+			begin := p.pos
+			for !p.end() && isNameChar(p.peek()) {
+				p.pos++
+			}
+			if p.end() || p.peek() != '>' {
+				return nil, p.errorf(ErrInvalidGroupName, Span{begin, p.pos}, p.pos, "group name at position %d not closed by '>'", begin).expecting(">")
+			}
+			if name = string(p.input[begin:p.pos]); name == "" {
+				return nil, p.errorf(ErrInvalidGroupName, Span{begin, begin}, begin, "empty group name at position %d", begin).expecting("<name>")
+			}
+			// names are printed alongside the numbers of unnamed groups
+			if '0' <= name[0] && name[0] <= '9' {
+				return nil, p.errorf(ErrInvalidGroupName, Span{begin, p.pos}, begin, "group name %q at position %d begins with a digit", name, begin)
+			}
+			if p.names[name] {
+				return nil, p.errorf(ErrDuplicateGroupName, Span{begin, p.pos}, begin, "duplicate group name %q at position %d", name, begin)
+			}
+			p.names[name] = true
+			p.pos++
+		case p.pos+1 < len(p.input) && (p.input[p.pos+1] == '-' || flagLetters[p.input[p.pos+1]] != 0):
+			p.pos++
+			set, err := p.flagLetters(start)
+			if err != nil {
+				return nil, err
+			}
+			p.pos++
+			if p.input[p.pos-1] == ')' {
+				p.flags = set
+				return nil, nil
+			}
+			p.flags, capturing = set, false
+		default:
+			return nil, p.errorf(ErrInvalidGroup, Span{start, p.pos + 1}, p.pos, "unknown group syntax at position %d", start)
+		}
+	}
+	index := 0
+	if capturing {
+		p.groups++
+		index = p.groups
+	}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.end() {
+		return nil, p.errorf(ErrMissingParen, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting(")")
+	}
+	p.pos++
+	p.flags = flags
+	return &Group{Span{start, p.pos}, n, index, name}, nil
+}
+
+// flags → letter { letter } [ '-' letter { letter } ] | '-' letter { letter }
+// The flags are returned as they stand after those of the letters are set,
+// and those following '-' cleared. The ')' or ':' that ends them is left.
+func (p *parser) flagLetters(start int) (Flags, error) {
+	flags, clear, letters := p.flags, false, 0
+	for ; !p.end(); p.pos++ {
+		switch c := p.peek(); {
+		case c == ')' || c == ':':
+			if letters == 0 {
+				return 0, p.errorf(ErrInvalidFlags, Span{p.pos, p.pos}, p.pos, "missing flag at position %d", p.pos).expecting(flagNames()...)
+			}
+			return flags, nil
+		case c == '-' && !clear:
+			clear, letters = true, 0
+		case flagLetters[c] != 0 && clear:
+			flags &^= flagLetters[c]
+			letters++
+		case flagLetters[c] != 0:
+			flags |= flagLetters[c]
+			letters++
+		default:
+			return 0, p.errorf(ErrInvalidFlags, Span{p.pos, p.pos + 1}, p.pos, "unknown flag %q at position %d", c, p.pos)
+		}
+	}
+	return 0, p.errorf(ErrInvalidFlags, Span{start, p.pos}, p.pos, "flag group at position %d not closed", start).expecting(")", ":")
+}
+
+// literal returns the node matching the rune, or under FoldCase the class of
+// its case variants.
+func (p *parser) literal(span Span, r rune) Node {
+	if p.flags&FoldCase != 0 && unicode.SimpleFold(r) != r {
+		return &Class{span, foldRanges([]rune{r, r}), false}
+	}
+	return &Literal{span, r}
+}
+
+// anchor returns the assertion of '^' or '$'.
+func (p *parser) anchor(span Span, c rune) Node {
+	anchors := map[rune][2]Anchor{'^': {BeginText, BeginLine}, '$': {EndText, EndLine}}[c]
+	if p.flags&MultiLine != 0 {
+		return &Assert{span, anchors[1]}
+	}
+	return &Assert{span, anchors[0]}
+}
+
+// fold returns the ranges, widened under FoldCase to the case variants of
+// the runes in them.
+func (p *parser) fold(ranges []rune) []rune {
+	if p.flags&FoldCase != 0 {
+		return foldRanges(ranges)
+	}
+	return ranges
+}
+
+// lookingAt reports whether the input continues with s.
+func (p *parser) lookingAt(s string) bool {
+	r := []rune(s)
+	return len(p.input)-p.pos >= len(r) && string(p.input[p.pos:p.pos+len(r)]) == s
+}
+
+// errorf returns the error found at pos, concerning the span of the pattern.
+func (p *parser) errorf(code ErrorCode, span Span, pos int, format string, args ...interface{}) *ParseError {
+	return newParseError(p.input, code, span, pos, format, args...)
+}
+
+// isNameChar reports whether c may appear in the name of a group.
+func isNameChar(c rune) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// class → '[' [ '^' ] item { item } ']'
+// item  → member [ '-' member ] | shorthand | '[:' classname ':]'
+func (p *parser) class() (Node, error) {
+	start := p.pos
+	p.pos++
+	n := &Class{}
+	if !p.end() && p.peek() == '^' {
+		n.Negated = true
+		p.pos++
+	}
+	var ranges []rune
+	for {
+		if p.end() {
+			return nil, p.errorf(ErrMissingBracket, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting("]")
+		}
+		if p.peek() == ']' {
+			if len(ranges) == 0 {
+				return nil, p.errorf(ErrEmptyClass, Span{start, p.pos + 1}, p.pos, "empty class at position %d", start).expecting("<item>")
+			}
+			break
+		}
+		item := p.pos
+		if p.atShorthand() {
+			r, negated, err := p.shorthand()
+			if err != nil {
+				return nil, err
+			}
+			if r = p.fold(r); negated {
+				r = negate(r)
+			}
+			ranges = append(ranges, r...)
+			continue
+		}
+		if p.lookingAt("[:") {
+			r, err := p.posixClass()
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, p.fold(r)...)
+			continue
+		}
+		lo, err := p.classSymbol()
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if !p.end() && p.peek() == '-' {
+			p.pos++
+			if p.end() {
+				return nil, p.errorf(ErrMissingBracket, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting("]")
+			}
+			if p.atShorthand() {
+				return nil, p.errorf(ErrInvalidRange, Span{item, p.pos + 2}, p.pos, "range at position %d ends in a shorthand class", item)
+			}
+			if hi, err = p.classSymbol(); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, p.errorf(ErrInvalidRange, Span{item, p.pos}, item, "range %q-%q at position %d is out of order", lo, hi, item)
+			}
+		}
+		ranges = append(ranges, lo, hi)
+	}
+	p.pos++
+	n.Span = Span{start, p.pos}
+	n.Ranges = p.fold(normalize(ranges))
+	return n, nil
+}
+
+// posixClass → '[:' name ':]'
+// The ranges of the class are returned.
+func (p *parser) posixClass() ([]rune, error) {
+	start := p.pos
+	p.pos += 2
+	begin := p.pos
+	for !p.end() && !p.lookingAt(":]") && p.peek() != ']' {
+		p.pos++
+	}
+	if !p.lookingAt(":]") {
+		return nil, p.errorf(ErrInvalidBracketClass, Span{start, p.pos}, p.pos, "character class at position %d not closed", start).expecting(":]")
+	}
+	name := string(p.input[begin:p.pos])
+	p.pos += 2
+	ranges, ok := posixClasses[name]
+	if !ok {
+		return nil, p.errorf(ErrInvalidBracketClass, Span{start, p.pos}, start, "unknown character class %q at position %d", name, start)
+	}
+	return ranges, nil
+}
+
+// atShorthand reports whether the input continues with a shorthand class.
+func (p *parser) atShorthand() bool {
+	return p.pos+1 < len(p.input) && p.peek() == '\\' && strings.ContainsRune("dswDSWpP", p.input[p.pos+1])
+}
+
+// shorthand → '\d' | '\w' | '\s' | '\D' | '\W' | '\S' | '\p' property | '\P' property
+// The ranges of the class are returned along with whether it is negated.
+func (p *parser) shorthand() ([]rune, bool, error) {
+	c := p.input[p.pos+1]
+	p.pos += 2
+	if c == 'p' || c == 'P' {
+		ranges, negated, err := p.property()
+		return ranges, negated != (c == 'P'), err
+	}
+	sets := shorthands[unicode.ToLower(c)]
+	if p.flags&UnicodeClasses != 0 {
+		return sets.unicode, unicode.IsUpper(c), nil
+	}
+	return sets.ascii, unicode.IsUpper(c), nil
+}
+
+// property → letter | '{' [ '^' ] table '}'
+// The ranges of the Unicode class are returned along with whether it is
+// negated.
+func (p *parser) property() ([]rune, bool, error) {
+	start := p.pos - 2
+	if p.end() {
+		return nil, false, p.errorf(ErrInvalidClassName, Span{start, p.pos}, p.pos, "missing Unicode class name at position %d", p.pos).expecting("<letter>", "{")
+	}
+	name, negated := string(p.peek()), false
+	p.pos++
+	if name == "{" {
+		begin := p.pos
+		for !p.end() && p.peek() != '}' {
+			p.pos++
+		}
+		if p.end() {
+			return nil, false, p.errorf(ErrInvalidClassName, Span{start, p.pos}, p.pos, "Unicode class at position %d not closed", start).expecting("}")
+		}
+		name = string(p.input[begin:p.pos])
+		p.pos++
+		if strings.HasPrefix(name, "^") {
+			name, negated = name[1:], true
+		}
+	}
+	ranges, ok := properties(name)
+	if !ok {
+		return nil, false, p.errorf(ErrInvalidClassName, Span{start, p.pos}, start, "unknown Unicode class %q at position %d", name, start)
+	}
+	return ranges, negated, nil
+}
+
+// member → escape | symbol
+func (p *parser) classSymbol() (rune, error) {
+	c := p.peek()
+	if c == '\\' {
+		return p.escape()
+	}
+	if !isSymbol(c) {
+		return 0, p.errorf(ErrInvalidSymbol, Span{p.pos, p.pos + 1}, p.pos, "%q at position %d is not an allowed symbol", c, p.pos)
+	}
+	p.pos++
+	return c, nil
+}
+
+// controls maps the letters of control escapes to the characters they stand
+// for.
+var controls = map[rune]rune{
+	'a': '\a', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+}
+
+// escape reads a backslash escape, as given in the grammar of ParseTree.
+func (p *parser) escape() (rune, error) {
+	start := p.pos
+	p.pos++
+	if p.end() {
+		return 0, p.errorf(ErrTrailingBackslash, Span{start, p.pos}, start, "trailing backslash at position %d", start)
+	}
+	c := p.peek()
+	p.pos++
+	if c < utf8.RuneSelf && (c == ' ' || unicode.IsPunct(c) || unicode.IsSymbol(c)) {
+		return c, nil
+	}
+	if r, ok := controls[c]; ok {
+		return r, nil
+	}
+	var r rune
+	var err error
+	switch c {
+	case 'x':
+		if !p.end() && p.peek() == '{' {
+			p.pos++
+			r, err = p.hex(0)
+			if err == nil && (p.end() || p.peek() != '}') {
+				err = p.errorf(ErrInvalidEscape, Span{start, p.pos}, p.pos, "escape at position %d not closed", start).expecting("}")
+			}
+			p.pos++
+		} else {
+			r, err = p.hex(2)
+		}
+	case 'u':
+		r, err = p.hex(4)
+	case 'U':
+		r, err = p.hex(8)
+	default:
+		return 0, p.errorf(ErrInvalidEscape, Span{start, p.pos}, start, "invalid escape %q at position %d", "\\"+string(c), start)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if r > unicode.MaxRune || (0xd800 <= r && r < 0xe000) {
+		return 0, p.errorf(ErrInvalidEscape, Span{start, p.pos}, start, "escape at position %d is not a valid code point", start)
+	}
+	return r, nil
+}
+
+// hex reads n hexadecimal digits, or if n is zero as many as there are.
+func (p *parser) hex(n int) (rune, error) {
+	var r rune
+	digits := 0
+	for ; !p.end() && (n == 0 || digits < n); digits++ {
+		c := p.peek()
+		var d rune
+		switch {
+		case '0' <= c && c <= '9':
+			d = c - '0'
+		case 'a' <= c && c <= 'f':
+			d = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			if n == 0 && digits > 0 {
+				return r, nil
+			}
+			return 0, p.errorf(ErrInvalidEscape, Span{p.pos, p.pos + 1}, p.pos, "%q at position %d is not a hexadecimal digit", c, p.pos).expecting("<hex>")
+		}
+		if r = r<<4 | d; r > unicode.MaxRune {
+			return 0, p.errorf(ErrInvalidEscape, Span{p.pos, p.pos + 1}, p.pos, "code point at position %d out of range", p.pos)
+		}
+		p.pos++
+	}
+	if digits == 0 || digits < n {
+		return 0, p.errorf(ErrInvalidEscape, Span{p.pos, p.pos}, p.pos, "missing hexadecimal digits at position %d", p.pos).expecting("<hex>")
+	}
+	return r, nil
+}
+
+/*
+ParseTree validates a regular expression and returns its syntax tree.
+
+The grammar is that of Sieve, with concatenation implicit:
+
+	expr   → inter { '|' inter }
+
+	inter  → concat { '&' concat }
+
+	concat → { closed | '(?' flags ')' }
+
+	closed → '~' closed
+	       | basic '*' [ '?' ]
+	       | basic '+' [ '?' ]
+	       | basic '?' [ '?' ]
+	       | basic repeat [ '?' ]
+	       | basic
+
+	repeat → '{' count '}'
+	       | '{' count ',' '}'
+	       | '{' count ',' count '}'
+
+	basic  → group
+	       | '.'
+	       | '^'
+	       | '$'
+	       | class
+	       | shorthand
+	       | escape
+	       | symbol
+
+	group  → '(' expr ')'
+	       | '(?:' expr ')'
+	       | '(?P<' name '>' expr ')'
+	       | '(?' flags ':' expr ')'
+
+	flags  → letter { letter } [ '-' letter { letter } ]
+	       | '-' letter { letter }
+
+	class  → '[' [ '^' ] item { item } ']'
+	item   → member [ '-' member ] | shorthand | '[:' classname ':]'
+	member → escape | symbol
+
+	shorthand → '\d' | '\w' | '\s' | '\D' | '\W' | '\S'
+	          | '\p' property | '\P' property
+	property  → letter | '{' [ '^' ] table '}'
+
+	escape → '\' punct
+	       | '\' control
+	       | '\x' hex hex | '\x{' hex { hex } '}'
+	       | '\u' hex hex hex hex | '\U' hex hex hex hex hex hex hex hex
+
+	symbol → a-Z | A-Z | 0-9 | any non-ASCII character
+
+The flags alter the meaning of some constructs: see Flags. Within a pattern,
+the letters i, m, s and x of a flag group stand for FoldCase, MultiLine, DotNL
+and FreeSpacing, which are set, or cleared after '-', until the end of the
+group (?flags:expr), or for (?flags) until the end of the group enclosing it.
+A punct is any ASCII punctuation character or space, and a control is one of
+the letters a, f, n, r, t and v, standing for the same characters as in Go.
+The shorthands \d, \w and \s stand for the classes of digits, word characters
+and white space, as in Go, and \D, \W and \S for their complements. A table is
+the name of a Unicode general category or script, such as L, Lu or Greek, or
+Any for every character; \p stands for the class of the table (\pL for a
+one-letter category), and \P or a table preceded by '^' for its complement. A
+classname is one of the POSIX classes alnum, alpha, blank, cntrl, digit,
+graph, lower, print, punct, space, upper and xdigit, in ASCII. A count is a
+decimal number; a repetition may not exceed DefaultMaxRepeat occurrences. A name is
+made of ASCII letters, digits and underscores, does not begin with a digit,
+and names no other group.
+
+The operators '*', '+', '?' and repeat prefer as many occurrences as possible;
+followed by '?', they are lazy and prefer as few.
+
+The operator '&' matches the strings that both of its operands match, and '~'
+the strings that its operand does not, including those with newlines. They
+are found by deterministic automata built from their operands, and prefer the
+longest match: their operands may not hold '^' nor '$', and the groups in them
+do not capture, nor count in the numbering of those that do.
+
+Alternation binds loosest, then intersection, then concatenation; all three
+are left associative. Under FreeSpacing, white space and comments may come
+before any closed and any closure operator.
+*/
+func ParseTree(regex string, flags Flags) (Node, error) {
+	return parseTree(regex, flags, DefaultMaxRepeat)
+}
+
+// parseTree is ParseTree with the given limit in place of DefaultMaxRepeat.
+func parseTree(regex string, flags Flags, maxRepeat int) (Node, error) {
+	p := parser{input: []rune(regex), flags: flags, maxRepeat: maxRepeat, names: map[string]bool{}}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.end() {
+		return nil, p.errorf(ErrUnexpectedParen, Span{p.pos, p.pos + 1}, p.pos, "unmatched ')' at position %d", p.pos)
+	}
+	renumber(n)
+	return n, nil
+}
+
+// renumber numbers the capturing groups of the tree from 1 in the order of
+// their opening parentheses, closing the gaps left by groups made
+// non-capturing in operands of '&' and '~'.
+func renumber(root Node) {
+	index := 0
+	var walk func(Node)
+	walk = func(n Node) {
+		if g, ok := n.(*Group); ok && g.Index > 0 {
+			index++
+			g.Index = index
+		}
+		for _, m := range children(n) {
+			walk(m)
+		}
+	}
+	walk(root)
+}
@@ -0,0 +1,277 @@
+package compiler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTree(t *testing.T) {
+	cases := map[string]string{
+		"a(b|c)*d":              "abc|*⋅d⋅",
+		"a(ab|c)*d":             "aab⋅c|*⋅d⋅",
+		"ab|cd":                 "ab⋅cd⋅|",
+		"(ab)|(cd)":             "ab⋅cd⋅|",
+		"a|b|c":                 "ab|c|",
+		"andrew|jackson":        "an⋅d⋅r⋅e⋅w⋅ja⋅c⋅k⋅s⋅o⋅n⋅|",
+		"(andrew)|(jackson)":    "an⋅d⋅r⋅e⋅w⋅ja⋅c⋅k⋅s⋅o⋅n⋅|",
+		"(a+b)+":                "a+b⋅+",
+		"":                      "ε",
+		"a|":                    "aε|",
+		"()":                    "ε",
+		"[a-c0-9x]+":            "[0-9a-cx]+",
+		"[^cba]":                "[^a-c]",
+		"[a-cb-d]":              "[a-d]",
+		`\(a\|b\)\*`:            `\(a⋅\|⋅b⋅\)⋅\*⋅`,
+		`\x41\x{42}\u0043\n`:    "AB⋅C⋅\\n⋅",
+		`[\]\\\-]`:              `[\-\\-\]]`,
+		`\U0001F600`:            "😀",
+		`\x{200b}`:              `\x{200b}`,
+		"café|naïve":            "ca⋅f⋅é⋅na⋅ï⋅v⋅e⋅|",
+		"日本語+":                  "日本⋅語+⋅",
+		"colou?r.":              "co⋅l⋅o⋅u?⋅r⋅.⋅",
+		"(ab)?|.*":              "ab⋅?.*|",
+		"a{2,5}b{3}(cd){0,}":    "a{2,5}b{3}⋅cd⋅{0,}⋅",
+		"(a{10}){100}":          "a{10}{100}",
+		"^ab|c$":                "^a⋅b⋅c$⋅|",
+		"^*":                    "^*",
+		"(?:ab)+(?P<x_1>c)":     "ab⋅+c⋅",
+		"x.*?y|a+?b??":          "x.*?⋅y⋅a+?b??⋅|",
+		"a{2,}?(b{1,3}?)":       "a{2,}?b{1,3}?⋅",
+		`\d+\.\d`:               `[0-9]+\.⋅[0-9]⋅`,
+		`[\w\-]\S`:              `[\-0-9A-Z\_a-z][^\t-\n\f-\r\ ]⋅`,
+		`[^\D\s]`:               `[^\x{0}-\/\:-\x{10ffff}]`,
+		"(?i)ab":                "[Aa][Bb]⋅",
+		"a(?i:b)c":              "a[Bb]⋅c⋅",
+		"(?i)k1":                "[Kk\u212a]1⋅",
+		"(a(?i)b|c)d":           "a[Bb]⋅[Cc]|d⋅",
+		"(?i)[^a-c\\d]":         "[^0-9A-Ca-c]",
+		"(?m)^a$(?-m)$":         "(?m:^)a⋅(?m:$)⋅$⋅",
+		"(?x) a b # c\n | c{2}": "ab⋅c{2}|",
+		"[a-z]+&~(if|fi)":       "[a-z]+if⋅fi⋅|~&",
+		"a|b&c|d":               "abc&|d|",
+		"ab&c*":                 "ab⋅c*&",
+		"a&b&c":                 "ab&c&",
+		"~a*b":                  "a*~b⋅",
+		"~~(?i)a":               "[Aa]~~",
+		"a&|~()":                "aε&ε~|",
+		"(?x) ~ a & b":          "a~b&",
+	}
+	for r, rpn := range cases {
+		tree, err := ParseTree(r, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := RPN(tree); rpn != out {
+			t.Fatalf("%q: expected %q got %q", r, rpn, out)
+		}
+	}
+}
+
+func TestParseTreeDotNL(t *testing.T) {
+	for flags, nl := range map[Flags]bool{0: false, DotNL: true} {
+		tree, err := ParseTree(".", flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any := tree.(*Any); any.NL != nl {
+			t.Fatalf("flags %d: expected NL %v got %v", flags, nl, any.NL)
+		}
+	}
+}
+
+func TestParseTreeUnicodeClasses(t *testing.T) {
+	cases := map[string]struct {
+		in, out []rune
+	}{
+		`\d`: {[]rune("0٣９"), []rune("a½")},
+		`\w`: {[]rune("_aé日\u0301"), []rune(" -")},
+		`\s`: {[]rune(" \t\u00a0\u2028"), []rune("a\u200b")},
+	}
+	for r, c := range cases {
+		tree, err := ParseTree(r, UnicodeClasses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		class := tree.(*Class)
+		for _, x := range c.in {
+			if !inRanges(class.Ranges, x) {
+				t.Fatalf("%q: expected %q inside the class", r, x)
+			}
+		}
+		for _, x := range c.out {
+			if inRanges(class.Ranges, x) {
+				t.Fatalf("%q: expected %q outside the class", r, x)
+			}
+		}
+	}
+}
+
+func inRanges(ranges []rune, x rune) bool {
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] <= x && x <= ranges[i+1] {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseTreeProperties(t *testing.T) {
+	cases := map[string]struct {
+		in, out []rune
+	}{
+		`\p{Greek}`:       {[]rune("αΩϰ"), []rune("a1")},
+		`\pN`:             {[]rune("1½٣"), []rune("a ")},
+		`\P{L}`:           {[]rune("1 !"), []rune("aé日")},
+		`\p{^Lu}`:         {[]rune("aß1"), []rune("AÉ")},
+		`[\p{Lu}\P{Any}]`: {[]rune("AΩ"), []rune("a1")},
+	}
+	for r, c := range cases {
+		tree, err := ParseTree(r, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		class := tree.(*Class)
+		for _, x := range c.in {
+			if inRanges(class.Ranges, x) == class.Negated {
+				t.Fatalf("%q: expected %q inside the class", r, x)
+			}
+		}
+		for _, x := range c.out {
+			if inRanges(class.Ranges, x) != class.Negated {
+				t.Fatalf("%q: expected %q outside the class", r, x)
+			}
+		}
+	}
+}
+
+func TestParseTreeFlags(t *testing.T) {
+	tree, err := ParseTree("(?s:.).", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concat := tree.(*Concat)
+	inner, outer := concat.A.(*Group).Sub.(*Any), concat.B.(*Any)
+	if !inner.NL || outer.NL {
+		t.Fatalf("expected NL only inside the group, got %v and %v", inner.NL, outer.NL)
+	}
+}
+
+func TestParseTreeSpans(t *testing.T) {
+	tree, err := ParseTree("a(b|c)*d", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := tree.(*Concat)
+	inner := outer.A.(*Concat)
+	star := inner.B.(*Star)
+	group := star.Sub.(*Group)
+	alternate := group.Sub.(*Alternate)
+	spans := map[Node]Span{
+		outer:       {0, 8},
+		inner:       {0, 7},
+		inner.A:     {0, 1},
+		star:        {1, 7},
+		group:       {1, 6},
+		alternate:   {2, 5},
+		alternate.B: {4, 5},
+		outer.B:     {7, 8},
+	}
+	for n, span := range spans {
+		if n.Pos() != span {
+			t.Fatalf("%T: expected span %v got %v", n, span, n.Pos())
+		}
+	}
+}
+
+// invalidPatterns are rejected by ParseTree.
+var invalidPatterns = []string{"(", "(ab", "a)", "*a", "a**", "a+*", "a???", "a*?+", "a{2}?*", "?a", "{2}", "a{", "a{x}", "a{2", "a{2,x}", "a{3,2}", "a{2}*", "(?", "(?y)", "(?i", "(?i-)", "(?-)", "(?i-m-s)", "(?i)*", "a(?i)+", "(?iy:a)", "(?x)a* *", "(?P<a", "(?P<>a)", "(?P<1a>a)", "(?P<a-b>c)", "(?P<a>b)(?P<a>c)", "(?:a", "a{1001}", "a{1000,}b{0,1001}", "(a{10}){101}", "(a{2,}){501}", "a-b", "[", "[]", "[a-", "[z-a]", "[a-]", `[a-\d]`, `[\d-z]`, `\p`, `\p{`, `\p{L`, `\p{}`, `\p{Foo}`, `\pX`, `[a-\pL]`, `\`, `\q`, `\x4`, `\x{}`, `\x{41`, `\x{110000}`, `\ud800`, `\u12`, "~", "a~", "~*", "a&~|b", "(~)", "~(?i)", "^a&b", "a&b$", "~(a|^b)"}
+
+func TestParseTreeErrors(t *testing.T) {
+	for _, r := range invalidPatterns {
+		if _, err := ParseTree(r, 0); !errors.As(err, new(*ParseError)) {
+			t.Fatalf("%q: expected a ParseError got %v", r, err)
+		}
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	cases := map[string]ParseError{
+		"(ab":              {Code: ErrMissingParen, Pos: 3, Offset: 3, Span: Span{0, 3}, Expected: []string{")"}},
+		"(é|ü":             {Code: ErrMissingParen, Pos: 4, Offset: 6, Span: Span{0, 4}, Expected: []string{")"}},
+		"日本)":              {Code: ErrUnexpectedParen, Pos: 2, Offset: 6, Span: Span{2, 3}},
+		"a**":              {Code: ErrNestedRepeat, Pos: 2, Offset: 2, Span: Span{2, 3}},
+		"a{2":              {Code: ErrInvalidRepeat, Pos: 3, Offset: 3, Span: Span{1, 3}, Expected: []string{",", "}"}},
+		"a{2,":             {Code: ErrInvalidRepeat, Pos: 4, Offset: 4, Span: Span{1, 4}, Expected: []string{"<count>", "}"}},
+		"[é-a]":            {Code: ErrInvalidRange, Pos: 1, Offset: 1, Span: Span{1, 4}},
+		"(?i-)":            {Code: ErrInvalidFlags, Pos: 4, Offset: 4, Span: Span{4, 4}, Expected: []string{"i", "m", "s", "x"}},
+		`ü\x{41`:           {Code: ErrInvalidEscape, Pos: 6, Offset: 7, Span: Span{1, 6}, Expected: []string{"}"}},
+		`\p{Greek`:         {Code: ErrInvalidClassName, Pos: 8, Offset: 8, Span: Span{0, 8}, Expected: []string{"}"}},
+		"[[:foo:]]":        {Code: ErrInvalidBracketClass, Pos: 1, Offset: 1, Span: Span{1, 8}},
+		"(?P<a>b)(?P<a>c)": {Code: ErrDuplicateGroupName, Pos: 12, Offset: 12, Span: Span{12, 13}},
+		"a-b":              {Code: ErrInvalidSymbol, Pos: 1, Offset: 1, Span: Span{1, 2}},
+		"ab|~":             {Code: ErrMissingOperand, Pos: 4, Offset: 4, Span: Span{3, 4}},
+		"(~(?i))":          {Code: ErrMissingOperand, Pos: 6, Offset: 6, Span: Span{1, 6}},
+		"é&^b":             {Code: ErrInvalidOperand, Pos: 2, Offset: 3, Span: Span{2, 3}},
+		"~(a$)":            {Code: ErrInvalidOperand, Pos: 3, Offset: 3, Span: Span{3, 4}},
+	}
+	for r, want := range cases {
+		var got *ParseError
+		if _, err := ParseTree(r, 0); !errors.As(err, &got) {
+			t.Fatalf("%q: expected a ParseError got %v", r, err)
+		}
+		want.Msg, want.Pattern = got.Msg, r
+		if !reflect.DeepEqual(*got, want) {
+			t.Fatalf("%q: expected %+v got %+v", r, want, *got)
+		}
+	}
+}
+
+// FuzzParseTree checks that no pattern makes the parser panic, and that each
+// error it reports is placed within the pattern.
+func FuzzParseTree(f *testing.F) {
+	for _, r := range invalidPatterns {
+		f.Add(r, uint8(0))
+	}
+	for _, r := range []string{"a(b|c)*d", "(?i)k+?", "(?x) a # b\n | c", `[\w\-]\pL{2,3}`, "(?P<x>a)(?m:^$)", "((a|)*)+", "[a-z]+&~(if|fi)"} {
+		f.Add(r, uint8(UnicodeClasses|FoldCase))
+	}
+	f.Fuzz(func(t *testing.T, r string, flags uint8) {
+		tree, err := ParseTree(r, Flags(flags))
+		if err == nil {
+			RPN(tree)
+			return
+		}
+		var e *ParseError
+		if !errors.As(err, &e) {
+			t.Fatalf("%q: expected a ParseError got %v", r, err)
+		}
+		runes := []rune(e.Pattern)
+		if e.Span.Start < 0 || e.Span.Start > e.Pos || e.Pos > e.Span.End || e.Span.End > len(runes) {
+			t.Fatalf("%q: error at %d spans %v", r, e.Pos, e.Span)
+		}
+		if e.Offset != len(string(runes[:e.Pos])) {
+			t.Fatalf("%q: error at %d has byte offset %d", r, e.Pos, e.Offset)
+		}
+	})
+}
+
+func TestParseTreeOperandGroups(t *testing.T) {
+	tree, err := ParseTree("((?P<x>a)&b)(c)|~(d)(?P<y>e)", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := SubexpNames(tree); !reflect.DeepEqual(names, []string{"", "", "", "y"}) {
+		t.Fatalf("expected the groups in operands not to capture, got names %q", names)
+	}
+}
+
+func TestFullMatch(t *testing.T) {
+	tree, err := ParseTree("a|b", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := RPN(FullMatch(tree)); out != "^ab|⋅$⋅" {
+		t.Fatalf("expected %q got %q", "^ab|⋅$⋅", out)
+	}
+}
@@ -0,0 +1,395 @@
+package compiler
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+parsePOSIX validates a pattern in the POSIX syntax, ERE or BRE, and returns
+its syntax tree, of the same nodes as that of ParseTree. The grammar of ERE is
+
+	ere     → branch { '|' branch }
+
+	branch  → { expression }
+
+	expression → atom [ dup ]
+
+	dup     → '*' | '+' | '?' | '{' count '}' | '{' count ',' '}'
+	        | '{' count ',' count '}'
+
+	atom    → '(' ere ')' | '.' | '^' | '$' | bracket | '\' punct | char
+
+and that of BRE
+
+	bre     → { expression }
+
+	expression → atom [ dup ]
+
+	dup     → '*' | '\{' count '\}' | '\{' count ',' '\}'
+	        | '\{' count ',' count '\}'
+
+	atom    → '\(' bre '\)' | '.' | '^' | '$' | bracket | '\' punct | char
+
+where in both
+
+	bracket → '[' [ '^' ] [ ']' ] item { item } ']'
+	item    → member [ '-' member ] | '[:' classname ':]' | '[=' char '=]'
+	member  → char | '[.' char '.]'
+
+A char is any character that has no other meaning where it stands, and a punct
+any ASCII punctuation character or space. In a BRE, '^' is an anchor only at
+the beginning of the pattern or of a group, and '$' only at the end; '*' is a
+char at the beginning, even after an anchoring '^'; and '+', '?', '{', '}',
+'|', '(' and ')' are chars. In a bracket, '\' is a char, as are ']' first and
+'-' first or last. Back-references, which are not regular, and the escapes of
+GNU such as '\|' are rejected.
+
+The flags are those of ParseTree but for FreeSpacing, which is ignored.
+*/
+func parsePOSIX(regex string, syntax Syntax, flags Flags, maxRepeat int) (Node, error) {
+	p := parser{input: []rune(regex), flags: flags &^ FreeSpacing, maxRepeat: maxRepeat, names: map[string]bool{}}
+	var n Node
+	var err error
+	if syntax == BRE {
+		n, err = p.bre(0)
+	} else {
+		n, err = p.ere()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.end() {
+		return nil, p.errorf(ErrUnexpectedParen, Span{p.pos, p.pos + 1}, p.pos, "unmatched ')' at position %d", p.pos)
+	}
+	return n, nil
+}
+
+// ere → branch { '|' branch }
+func (p *parser) ere() (Node, error) {
+	n, err := p.branch()
+	if err != nil {
+		return nil, err
+	}
+	for !p.end() && p.peek() == '|' {
+		p.pos++
+		m, err := p.branch()
+		if err != nil {
+			return nil, err
+		}
+		n = &Alternate{Span{n.Pos().Start, m.Pos().End}, n, m}
+	}
+	return n, nil
+}
+
+// branch → { expression }
+func (p *parser) branch() (Node, error) {
+	start := p.pos
+	var n Node
+	for !p.end() && p.peek() != '|' && p.peek() != ')' {
+		m, err := p.ereAtom()
+		if err != nil {
+			return nil, err
+		}
+		if m, err = p.dup(m, false); err != nil {
+			return nil, err
+		}
+		n = join(n, m)
+	}
+	if n == nil {
+		return &Empty{Span{start, start}}, nil
+	}
+	return n, nil
+}
+
+// atom → '(' ere ')' | '.' | '^' | '$' | bracket | '\' punct | char
+func (p *parser) ereAtom() (Node, error) {
+	start := p.pos
+	switch c := p.peek(); c {
+	case '(':
+		p.pos++
+		p.groups++
+		index := p.groups
+		n, err := p.ere()
+		if err != nil {
+			return nil, err
+		}
+		if p.end() {
+			return nil, p.errorf(ErrMissingParen, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting(")")
+		}
+		p.pos++
+		return &Group{Span{start, p.pos}, n, index, ""}, nil
+	case '*', '+', '?', '{':
+		return nil, p.errorf(ErrMissingRepeatArg, Span{start, start + 1}, start, "missing argument to %q at position %d", c, start)
+	case '\\':
+		return p.posixEscape(false)
+	}
+	return p.posixAtom()
+}
+
+// bre → { expression }
+// The depth is the number of groups open around the expressions.
+func (p *parser) bre(depth int) (Node, error) {
+	begin := p.pos
+	var n Node
+	for !p.end() && !(depth > 0 && p.lookingAt(`\)`)) {
+		start := p.pos
+		var m Node
+		var err error
+		switch c := p.peek(); {
+		case p.lookingAt(`\(`):
+			p.pos += 2
+			p.groups++
+			index := p.groups
+			if m, err = p.bre(depth + 1); err != nil {
+				return nil, err
+			}
+			if p.end() {
+				return nil, p.errorf(ErrMissingParen, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting(`\)`)
+			}
+			p.pos += 2
+			m = &Group{Span{start, p.pos}, m, index, ""}
+		case p.lookingAt(`\)`):
+			return nil, p.errorf(ErrUnexpectedParen, Span{start, start + 2}, start, "unmatched %q at position %d", `\)`, start)
+		case p.lookingAt(`\{`):
+			return nil, p.errorf(ErrMissingRepeatArg, Span{start, start + 2}, start, "missing argument to %q at position %d", `\{`, start)
+		case c == '\\':
+			if m, err = p.posixEscape(true); err != nil {
+				return nil, err
+			}
+		case c == '^' && start == begin:
+			// an anchor takes no duplication, so a '*' after it is a char
+			p.pos++
+			n = p.anchor(Span{start, p.pos}, c)
+			continue
+		case c == '$' && p.breEnd(depth):
+			p.pos++
+			m = p.anchor(Span{start, p.pos}, c)
+		case c == '*' && (start == begin || (start == begin+1 && p.input[begin] == '^')):
+			p.pos++
+			m = p.literal(Span{start, p.pos}, c)
+		case c == '^' || c == '$':
+			p.pos++
+			m = p.literal(Span{start, p.pos}, c)
+		default:
+			if m, err = p.posixAtom(); err != nil {
+				return nil, err
+			}
+		}
+		if m, err = p.dup(m, true); err != nil {
+			return nil, err
+		}
+		n = join(n, m)
+	}
+	if n == nil {
+		return &Empty{Span{begin, begin}}, nil
+	}
+	return n, nil
+}
+
+// breEnd reports whether the '$' at the position of the parser ends the
+// pattern, or the group if depth shows that one is open.
+func (p *parser) breEnd(depth int) bool {
+	p.pos++
+	defer func() { p.pos-- }()
+	return p.end() || (depth > 0 && p.lookingAt(`\)`))
+}
+
+// join returns the concatenation of n and m, or m if n is nil.
+func join(n, m Node) Node {
+	if n == nil {
+		return m
+	}
+	return &Concat{Span{n.Pos().Start, m.Pos().End}, n, m}
+}
+
+// posixAtom reads the atoms that mean the same in ERE and BRE wherever they
+// stand: '.', a bracket and a char, and in ERE '^' and '$'.
+func (p *parser) posixAtom() (Node, error) {
+	start := p.pos
+	c := p.peek()
+	if c == '[' {
+		return p.bracket()
+	}
+	p.pos++
+	switch c {
+	case '.':
+		return &Any{Span{start, p.pos}, p.flags&DotNL != 0}, nil
+	case '^', '$':
+		return p.anchor(Span{start, p.pos}, c), nil
+	}
+	return p.literal(Span{start, p.pos}, c), nil
+}
+
+// dup reads the duplication, if any, that follows n, in BRE if bre is set.
+func (p *parser) dup(n Node, bre bool) (Node, error) {
+	isDup := func() bool {
+		if bre {
+			return p.lookingAt("*") || p.lookingAt(`\{`)
+		}
+		return !p.end() && isClosure(p.peek())
+	}
+	if !isDup() {
+		return n, nil
+	}
+	start := p.pos
+	c := p.peek()
+	// POSIX leaves the duplication of an anchor undefined, and it is
+	// rejected; in BRE a '*' after an anchor is a char and never gets here
+	if _, ok := n.(*Assert); ok {
+		return nil, p.errorf(ErrMissingRepeatArg, Span{start, start + 1}, start, "missing argument to %q at position %d", c, start)
+	}
+	span := func() Span { return Span{n.Pos().Start, p.pos} }
+	var m Node
+	switch {
+	case c == '*':
+		// BRE leaves '**' undefined, and grep reads it as '*'
+		for p.pos++; bre && p.lookingAt("*"); p.pos++ {
+		}
+		m = &Star{span(), n, false}
+	case c == '+':
+		p.pos++
+		m = &Plus{span(), n, false}
+	case c == '?':
+		p.pos++
+		m = &Quest{span(), n, false}
+	default:
+		open, close := "{", "}"
+		if bre {
+			open, close = `\{`, `\}`
+		}
+		min, max, err := p.repeat(open, close)
+		if err != nil {
+			return nil, err
+		}
+		if repeatSize(&Repeat{Sub: n, Min: min, Max: max}) > p.maxRepeat {
+			return nil, p.errorf(ErrRepeatSize, Span{start, p.pos}, start, "repetition at position %d exceeds %d occurrences", start, p.maxRepeat)
+		}
+		m = &Repeat{span(), n, min, max, false}
+	}
+	if isDup() {
+		return nil, p.errorf(ErrNestedRepeat, Span{p.pos, p.pos + 1}, p.pos, "double closures not allowed at position %d", p.pos)
+	}
+	return m, nil
+}
+
+// posixEscape reads '\' punct, outside a bracket, in BRE if bre is set.
+func (p *parser) posixEscape(bre bool) (Node, error) {
+	start := p.pos
+	p.pos++
+	if p.end() {
+		return nil, p.errorf(ErrTrailingBackslash, Span{start, p.pos}, start, "trailing backslash at position %d", start)
+	}
+	c := p.peek()
+	p.pos++
+	switch {
+	case '1' <= c && c <= '9':
+		return nil, p.errorf(ErrInvalidEscape, Span{start, p.pos}, start, "back-reference %q at position %d is not regular", "\\"+string(c), start)
+	case bre && (c == '|' || c == '+' || c == '?'):
+		return nil, p.errorf(ErrInvalidEscape, Span{start, p.pos}, start, "escape %q at position %d is a GNU extension", "\\"+string(c), start)
+	case c < utf8.RuneSelf && (c == ' ' || unicode.IsPunct(c) || unicode.IsSymbol(c)):
+		return p.literal(Span{start, p.pos}, c), nil
+	}
+	return nil, p.errorf(ErrInvalidEscape, Span{start, p.pos}, start, "invalid escape %q at position %d", "\\"+string(c), start)
+}
+
+// bracket → '[' [ '^' ] [ ']' ] item { item } ']'
+// item    → member [ '-' member ] | '[:' classname ':]' | '[=' char '=]'
+func (p *parser) bracket() (Node, error) {
+	start := p.pos
+	p.pos++
+	n := &Class{}
+	if !p.end() && p.peek() == '^' {
+		n.Negated = true
+		p.pos++
+	}
+	var ranges []rune
+	for first := true; ; first = false {
+		if p.end() {
+			return nil, p.errorf(ErrMissingBracket, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting("]")
+		}
+		if p.peek() == ']' && !first {
+			break
+		}
+		item := p.pos
+		if p.lookingAt("[:") || p.lookingAt("[=") {
+			var r []rune
+			var err error
+			if p.lookingAt("[:") {
+				r, err = p.posixClass()
+			} else {
+				var c rune
+				c, err = p.element('=')
+				r = []rune{c, c}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if p.lookingAt("-") && !p.lookingAt("-]") {
+				return nil, p.errorf(ErrInvalidRange, Span{item, p.pos + 1}, p.pos, "range at position %d begins with a class", item)
+			}
+			ranges = append(ranges, r...)
+			continue
+		}
+		lo, err := p.member()
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if p.lookingAt("-") && !p.lookingAt("-]") {
+			p.pos++
+			if p.end() {
+				return nil, p.errorf(ErrMissingBracket, Span{start, p.pos}, p.pos, "bracket opened at position %d not closed", start).expecting("]")
+			}
+			if p.lookingAt("[:") || p.lookingAt("[=") {
+				return nil, p.errorf(ErrInvalidRange, Span{item, p.pos + 2}, p.pos, "range at position %d ends in a class", item)
+			}
+			if hi, err = p.member(); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, p.errorf(ErrInvalidRange, Span{item, p.pos}, item, "range %q-%q at position %d is out of order", lo, hi, item)
+			}
+		}
+		ranges = append(ranges, lo, hi)
+	}
+	p.pos++
+	n.Span = Span{start, p.pos}
+	n.Ranges = p.fold(normalize(ranges))
+	return n, nil
+}
+
+// member → char | '[.' char '.]'
+func (p *parser) member() (rune, error) {
+	if p.lookingAt("[.") {
+		return p.element('.')
+	}
+	c := p.peek()
+	p.pos++
+	return c, nil
+}
+
+// elementNames names the bracketed elements by their delimiters.
+var elementNames = map[rune]string{'.': "collating symbol", '=': "equivalence class"}
+
+// element → '[' delim char delim ']'
+// where delim is '.' for a collating symbol or '=' for an equivalence class,
+// which in the POSIX locale both stand for the char alone.
+func (p *parser) element(delim rune) (rune, error) {
+	start := p.pos
+	p.pos += 2
+	begin := p.pos
+	close := string(delim) + "]"
+	for !p.end() && !p.lookingAt(close) {
+		p.pos++
+	}
+	if p.end() {
+		return 0, p.errorf(ErrMissingBracket, Span{start, p.pos}, p.pos, "%s at position %d not closed", elementNames[delim], start).expecting(close)
+	}
+	name := p.input[begin:p.pos]
+	p.pos += 2
+	if len(name) != 1 {
+		return 0, p.errorf(ErrInvalidBracketClass, Span{start, p.pos}, start, "%s %q at position %d is not a single character", elementNames[delim], string(name), start)
+	}
+	return name[0], nil
+}
@@ -0,0 +1,109 @@
+package compiler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePOSIX(t *testing.T) {
+	cases := []struct {
+		syntax       Syntax
+		pattern, rpn string
+	}{
+		{ERE, "a(b|c)*d", "abc|*⋅d⋅"},
+		{ERE, "a|", "aε|"},
+		{ERE, "()", "ε"},
+		{ERE, "a+b?c{2,3}", "a+b?⋅c{2,3}⋅"},
+		{ERE, "^a|b$", "^a⋅b$⋅|"},
+		{ERE, "a^b", "a^⋅b⋅"},
+		{ERE, `\(\.\\`, `\(\.⋅\\⋅`},
+		{ERE, "x}] -", `x\}⋅\]⋅\ ⋅\-⋅`},
+		{ERE, "[]a-]", `[\-\]a]`},
+		{ERE, "[^]a]", `[^\]a]`},
+		{ERE, `[\n]`, `[\\n]`},
+		{ERE, "[[:digit:][:upper:]x]", "[0-9A-Zx]"},
+		{ERE, "[[=x=][.-.]-/]", `[\--\/x]`},
+		{ERE, "[a-]", `[\-a]`},
+		{BRE, `\(ab\)*c\{2,\}`, "ab⋅*c{2,}⋅"},
+		{BRE, "a+b?{1}|(c)", `a\+⋅b⋅\?⋅\{⋅1⋅\}⋅\|⋅\(⋅c⋅\)⋅`},
+		{BRE, "^*a", `^\*⋅a⋅`},
+		{BRE, "*a*", `\*a*⋅`},
+		{BRE, "a**b", "a*b⋅"},
+		{BRE, `\(*a\)`, `\*a⋅`},
+		{BRE, "a^b$c", `a\^⋅b⋅\$⋅c⋅`},
+		{BRE, `\(^a$\)b$`, "^a⋅$⋅b⋅$⋅"},
+		{BRE, `a\.\*`, `a\.⋅\*⋅`},
+		{BRE, "", "ε"},
+		{BRE, `\(\)`, "ε"},
+	}
+	for _, c := range cases {
+		prog, err := Parse(c.pattern, Options{Syntax: c.syntax})
+		if err != nil {
+			t.Fatalf("%q: %v", c.pattern, err)
+		}
+		if _, ok := prog.Tree.(*Longest); !ok {
+			t.Fatalf("%q: expected a Longest root got %T", c.pattern, prog.Tree)
+		}
+		if out := RPN(prog.Tree); c.rpn != out {
+			t.Fatalf("%q: expected %q got %q", c.pattern, c.rpn, out)
+		}
+		automaton, err := prog.NFA()
+		if err != nil {
+			t.Fatalf("%q: %v", c.pattern, err)
+		}
+		if !automaton.Longest {
+			t.Fatalf("%q: expected a longest-match NFA", c.pattern)
+		}
+		if _, err := prog.Matcher(); err != nil {
+			t.Fatalf("%q: %v", c.pattern, err)
+		}
+	}
+}
+
+func TestParsePOSIXErrors(t *testing.T) {
+	cases := []struct {
+		syntax  Syntax
+		pattern string
+		code    ErrorCode
+		pos     int
+	}{
+		{ERE, "(ab", ErrMissingParen, 3},
+		{ERE, "ab)", ErrUnexpectedParen, 2},
+		{ERE, "*a", ErrMissingRepeatArg, 0},
+		{ERE, "a|+", ErrMissingRepeatArg, 2},
+		{ERE, "a**", ErrNestedRepeat, 2},
+		{ERE, "a+?", ErrNestedRepeat, 2},
+		{ERE, "^*", ErrMissingRepeatArg, 1},
+		{ERE, "a|$+", ErrMissingRepeatArg, 3},
+		{ERE, "a{2", ErrInvalidRepeat, 3},
+		{ERE, "a{1001}", ErrRepeatSize, 1},
+		{ERE, `(a)\1`, ErrInvalidEscape, 3},
+		{ERE, `\d`, ErrInvalidEscape, 0},
+		{ERE, `a\`, ErrTrailingBackslash, 1},
+		{ERE, "[]", ErrMissingBracket, 2},
+		{ERE, "[z-a]", ErrInvalidRange, 1},
+		{ERE, "[[:foo:]]", ErrInvalidBracketClass, 1},
+		{ERE, "[[:alpha:", ErrInvalidBracketClass, 9},
+		{ERE, "[[.ab.]]", ErrInvalidBracketClass, 1},
+		{ERE, "[[=a]", ErrMissingBracket, 5},
+		{ERE, "[a-[:digit:]]", ErrInvalidRange, 3},
+		{ERE, "[[:digit:]-z]", ErrInvalidRange, 10},
+		{BRE, `\(ab`, ErrMissingParen, 4},
+		{BRE, `ab\)`, ErrUnexpectedParen, 2},
+		{BRE, `\{2\}`, ErrMissingRepeatArg, 0},
+		{BRE, `a\{2`, ErrInvalidRepeat, 4},
+		{BRE, `a\{2}`, ErrInvalidRepeat, 4},
+		{BRE, `a*\{2\}`, ErrNestedRepeat, 2},
+		{BRE, `a\|b`, ErrInvalidEscape, 1},
+		{BRE, `\(a\)\1`, ErrInvalidEscape, 5},
+	}
+	for _, c := range cases {
+		var e *ParseError
+		if _, err := Parse(c.pattern, Options{Syntax: c.syntax}); !errors.As(err, &e) {
+			t.Fatalf("%q: expected a ParseError got %v", c.pattern, err)
+		}
+		if e.Code != c.code || e.Pos != c.pos {
+			t.Fatalf("%q: expected %q at %d got %q at %d: %s", c.pattern, c.code, c.pos, e.Code, e.Pos, e)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"thompson-regex/assembler"
+	"thompson-regex/nfa"
+)
+
+// A Syntax is a dialect in which Parse reads patterns.
+type Syntax uint8
+
+const (
+	// Native is the dialect of ParseTree.
+	Native Syntax = iota
+	// ERE is the POSIX extended regular expression syntax, as of egrep.
+	ERE
+	// BRE is the POSIX basic regular expression syntax, as of grep and sed.
+	BRE
+	// Go is the syntax of Go's regexp package, parsed by regexp/syntax.
+	Go
+)
+
+// Syntaxes maps the names of the dialects to them.
+var Syntaxes = map[string]Syntax{"native": Native, "ere": ERE, "bre": BRE, "go": Go}
+
+// Options configure Parse. The zero value parses a pattern as ParseTree does
+// with no flags.
+type Options struct {
+	// Flags alter the meaning of the pattern, as for ParseTree.
+	Flags Flags
+	// MaxRepeat is the largest number of occurrences a repetition may count,
+	// or if zero DefaultMaxRepeat.
+	MaxRepeat int
+	// FullMatch anchors the pattern at both ends, so that it matches only the
+	// whole of an input.
+	FullMatch bool
+	// Syntax is the dialect of the pattern. Patterns in ERE and BRE follow
+	// POSIX in preferring the longest of the matches that begin leftmost.
+	// Patterns in Go are parsed by regexp/syntax and simplified, then turned
+	// into syntax trees by Import; of the flags, only FoldCase, DotNL and
+	// MultiLine apply to them, and regexp/syntax rejects counts above 1000
+	// even if MaxRepeat is larger.
+	Syntax Syntax
+}
+
+// A Program is a parsed pattern, from which the matchers for the assemblers
+// and the automata are made.
+type Program struct {
+	// Pattern is the pattern as given to Parse.
+	Pattern string
+	// Tree is its syntax tree.
+	Tree Node
+}
+
+// Parse validates a pattern and returns its program. It never panics, nor
+// touches the package's variables, so that it is safe for other programs to
+// call on any input; a pattern it rejects is reported by a ParseError.
+func Parse(pattern string, opts Options) (*Program, error) {
+	maxRepeat := opts.MaxRepeat
+	if maxRepeat == 0 {
+		maxRepeat = DefaultMaxRepeat
+	}
+	var tree Node
+	var err error
+	switch opts.Syntax {
+	case Native:
+		tree, err = parseTree(pattern, opts.Flags, maxRepeat)
+	case Go:
+		tree, err = parseGo(pattern, opts.Flags, maxRepeat)
+	default:
+		tree, err = parsePOSIX(pattern, opts.Syntax, opts.Flags, maxRepeat)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.FullMatch {
+		tree = FullMatch(tree)
+	}
+	if opts.Syntax == ERE || opts.Syntax == BRE {
+		tree = &Longest{tree.Pos(), tree}
+	}
+	return &Program{pattern, tree}, nil
+}
+
+// Matcher returns the matcher generator of the program, for the assemblers.
+func (prog *Program) Matcher() (assembler.MatcherGenerator, error) {
+	return Compile(prog.Tree)
+}
+
+// NFA returns the automaton of the program, built by Thompson's construction.
+func (prog *Program) NFA() (*nfa.NFA, error) {
+	return NFA(prog.Tree)
+}
+
+// SubexpNames returns the names of the capturing groups of the program, as
+// SubexpNames does for its tree.
+func (prog *Program) SubexpNames() []string {
+	return SubexpNames(prog.Tree)
+}
@@ -0,0 +1,56 @@
+package compiler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]struct {
+		opts Options
+		rpn  string
+	}{
+		"a(b|c)*d":   {Options{}, "abc|*⋅d⋅"},
+		"a|b":        {Options{FullMatch: true}, "^ab|⋅$⋅"},
+		"a|(?-i:b).": {Options{Flags: FoldCase}, "[Aa]b.⋅|"},
+		"a{2000}":    {Options{MaxRepeat: 2000}, "a{2000}"},
+		"(a{2}){50}": {Options{MaxRepeat: 100}, "a{2}{50}"},
+	}
+	for r, c := range cases {
+		prog, err := Parse(r, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := RPN(prog.Tree); c.rpn != out {
+			t.Fatalf("%q: expected %q got %q", r, c.rpn, out)
+		}
+		if _, err := prog.Matcher(); err != nil {
+			t.Fatalf("%q: %v", r, err)
+		}
+		if _, err := prog.NFA(); err != nil {
+			t.Fatalf("%q: %v", r, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]struct {
+		opts Options
+		code ErrorCode
+	}{
+		"a{1001}":    {Options{}, ErrRepeatSize},
+		"a{6}":       {Options{MaxRepeat: 5}, ErrRepeatSize},
+		"(a{2}){51}": {Options{MaxRepeat: 100}, ErrRepeatSize},
+		"(ab":        {Options{FullMatch: true}, ErrMissingParen},
+		"a)":         {Options{}, ErrUnexpectedParen},
+	}
+	for r, c := range cases {
+		var e *ParseError
+		if _, err := Parse(r, c.opts); !errors.As(err, &e) {
+			t.Fatalf("%q: expected a ParseError got %v", r, err)
+		}
+		if e.Code != c.code {
+			t.Fatalf("%q: expected %q got %q", r, c.code, e.Code)
+		}
+	}
+}
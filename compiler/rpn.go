@@ -1,11 +1,6 @@
 package compiler
 
-import (
-	"fmt"
-	"strings"
-)
-
-func expr(input []rune, w *strings.Builder) (int, error) {
+func expr(input []rune, w *translation) (int, error) {
 	n, err := concat(input, w)
 	if err != nil {
 		return 0, err
@@ -17,13 +12,14 @@ func expr(input []rune, w *strings.Builder) (int, error) {
 	return n + m, nil
 }
 
-func union(input []rune, w *strings.Builder) (int, error) {
+func union(input []rune, w *translation) (int, error) {
 	// ε is permissible
 	if end(input) {
 		return 0, nil
 	}
 	if input[0] != '|' {
-		return 0, fmt.Errorf("nonempty union must start with '|'")
+		at := w.at(input)
+		return 0, w.errorf(ErrInvalidSymbol, Span{at, at + 1}, at, "%q at position %d is not an allowed symbol", input[0], at)
 	}
 	n, err := concat(input[1:], w)
 	if err != nil {
@@ -37,7 +33,7 @@ func union(input []rune, w *strings.Builder) (int, error) {
 	return 1 + n + m, nil
 }
 
-func concat(input []rune, w *strings.Builder) (int, error) {
+func concat(input []rune, w *translation) (int, error) {
 	n, err := closed(input, w)
 	if err != nil {
 		return 0, err
@@ -49,7 +45,7 @@ func concat(input []rune, w *strings.Builder) (int, error) {
 	return n + m, nil
 }
 
-func rest(input []rune, w *strings.Builder) (int, error) {
+func rest(input []rune, w *translation) (int, error) {
 	// ε is permissible
 	if end(input) {
 		return 0, nil
@@ -69,13 +65,13 @@ func rest(input []rune, w *strings.Builder) (int, error) {
 	return 1 + n + m, nil
 }
 
-func closed(input []rune, w *strings.Builder) (int, error) {
+func closed(input []rune, w *translation) (int, error) {
 	n, err := basic(input, w)
 	if err != nil {
 		return 0, err
 	}
 	if !end(input[n:]) {
-		if c := input[n]; c == '*' || c == '+' {
+		if c := input[n]; c == '*' || c == '+' || c == '?' {
 			w.WriteRune(c)
 			return n + 1, nil
 		}
@@ -84,11 +80,12 @@ func closed(input []rune, w *strings.Builder) (int, error) {
 }
 
 // A ntparser is a parser for a nonterminal.
-type ntparser func(input []rune, w *strings.Builder) (int, error)
+type ntparser func(input []rune, w *translation) (int, error)
 
-func basic(input []rune, w *strings.Builder) (int, error) {
-	// ε is permissible
-	if end(input) {
+func basic(input []rune, w *translation) (int, error) {
+	// ε is permissible, and written so that each operator has its operands
+	if end(input) || input[0] == '|' {
+		w.WriteRune('ε')
 		return 0, nil
 	}
 	if input[0] == '(' {
@@ -96,12 +93,12 @@ func basic(input []rune, w *strings.Builder) (int, error) {
 		if err != nil {
 			return 1, err
 		}
-		if input[n+1] != ')' {
-			return 0, fmt.Errorf("bracket not closed")
+		if err := closeBracket(input, n, w); err != nil {
+			return 0, err
 		}
 		return n + 2, nil
 	}
-	return 1, symbol(input[0], w)
+	return 1, symbol(input, w)
 }
 
 /*
@@ -119,18 +116,18 @@ We make use of the following language-and-translation scheme:
 
     closed → basic *  { print('*') }
            | basic +  { print('+') }
+           | basic ?  { print('?') }
            | basic
 
     basic  → ( expr )
            | symbol   { print(symbol) }
-           | ε
+           | ε        { print('ε') }
+
+    symbol → a-Z | A-Z | 0-9 | . | any non-ASCII character but ⋅
 
-    symbol → a-Z | A-Z | 0-9
+The empty expressions are written as 'ε', as RPN writes them, so that each
+operator of the result has its operands.
 */
 func RPNConvert(regex string) (string, error) {
-	var buf strings.Builder
-	if _, err := expr([]rune(regex), &buf); err != nil {
-		return "", fmt.Errorf("%s: partial result %q", err, buf.String())
-	}
-	return buf.String(), nil
+	return translate(regex, expr)
 }
@@ -10,6 +10,7 @@ func TestRPNConvert(t *testing.T) {
 		"(a⋅b)|(c⋅d)":  "ab⋅cd⋅|",
 		"a⋅n⋅d⋅r⋅e⋅w|j⋅a⋅c⋅k⋅s⋅o⋅n":     "an⋅d⋅r⋅e⋅w⋅ja⋅c⋅k⋅s⋅o⋅n⋅|",
 		"(a⋅n⋅d⋅r⋅e⋅w)|(j⋅a⋅c⋅k⋅s⋅o⋅n)": "an⋅d⋅r⋅e⋅w⋅ja⋅c⋅k⋅s⋅o⋅n⋅|",
+		"c⋅o⋅l⋅o⋅u?⋅r⋅.":                "co⋅l⋅o⋅u?⋅r⋅.⋅",
 	}
 	for r, rpn := range cases {
 		out, err := RPNConvert(r)
@@ -21,3 +22,23 @@ func TestRPNConvert(t *testing.T) {
 		}
 	}
 }
+
+func TestRPNConvertEmpty(t *testing.T) {
+	for _, r := range []string{"", "()", "a|", "(|a)", "a||b", "(a|)*b", "a(b|c)*d", "colou?r."} {
+		sieved, err := Sieve(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := RPNConvert(sieved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := ParseTree(r, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rpn := RPN(tree); rpn != out {
+			t.Fatalf("%q: expected %q got %q", r, rpn, out)
+		}
+	}
+}
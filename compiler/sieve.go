@@ -1,16 +1,30 @@
 package compiler
 
-import (
-	"errors"
-	"fmt"
-	"strings"
-)
+import "strings"
+
+// A translation is the output that Sieve or RPNConvert writes, along with the
+// pattern it translates. Each of their functions reads the rest of the
+// pattern from where it is, and places errors by what remains of it.
+type translation struct {
+	strings.Builder
+	pattern []rune
+}
+
+// at returns the position in the pattern of the rest of it, input.
+func (w *translation) at(input []rune) int {
+	return len(w.pattern) - len(input)
+}
+
+// errorf returns the error found at pos, concerning the span of the pattern.
+func (w *translation) errorf(code ErrorCode, span Span, pos int, format string, args ...interface{}) *ParseError {
+	return newParseError(w.pattern, code, span, pos, format, args...)
+}
 
 func end(input []rune) bool {
 	return len(input) == 0 || input[0] == ')'
 }
 
-func exprSieve(input []rune, w *strings.Builder) (int, error) {
+func exprSieve(input []rune, w *translation) (int, error) {
 	n, err := concatSieve(input, w)
 	if err != nil {
 		return 0, err
@@ -22,13 +36,14 @@ func exprSieve(input []rune, w *strings.Builder) (int, error) {
 	return n + m, nil
 }
 
-func unionSieve(input []rune, w *strings.Builder) (int, error) {
+func unionSieve(input []rune, w *translation) (int, error) {
 	// ε is permissible
 	if end(input) {
 		return 0, nil
 	}
 	if input[0] != '|' {
-		return 0, fmt.Errorf("nonempty union must start with '|'")
+		at := w.at(input)
+		return 0, w.errorf(ErrInvalidSymbol, Span{at, at + 1}, at, "%q at position %d is not an allowed symbol", input[0], at)
 	}
 	w.WriteRune('|')
 	n, err := concatSieve(input[1:], w)
@@ -42,7 +57,7 @@ func unionSieve(input []rune, w *strings.Builder) (int, error) {
 	return 1 + n + m, nil
 }
 
-func concatSieve(input []rune, w *strings.Builder) (int, error) {
+func concatSieve(input []rune, w *translation) (int, error) {
 	n, err := closedSieve(input, w)
 	if err != nil {
 		return 0, err
@@ -54,18 +69,18 @@ func concatSieve(input []rune, w *strings.Builder) (int, error) {
 	return n + m, nil
 }
 
-func restSieve(input []rune, w *strings.Builder) (int, error) {
+func restSieve(input []rune, w *translation) (int, error) {
 	// ε is permissible
 	if end(input) {
 		return 0, nil
 	}
-	var buf strings.Builder
+	if input[0] == '|' {
+		return 0, nil // allows backtracking
+	}
+	buf := translation{pattern: w.pattern}
 	n, err := closedSieve(input, &buf)
 	if err != nil {
-		if err == errDoubleClosure {
-			return 0, err
-		}
-		return 0, nil // allows backtracking
+		return 0, err
 	}
 	w.WriteRune('⋅')
 	w.WriteString(buf.String())
@@ -76,18 +91,17 @@ func restSieve(input []rune, w *strings.Builder) (int, error) {
 	return n + m, nil
 }
 
-var errDoubleClosure = errors.New("double closures not allowed")
-
-func closedSieve(input []rune, w *strings.Builder) (int, error) {
+func closedSieve(input []rune, w *translation) (int, error) {
 	n, err := basicSieve(input, w)
 	if err != nil {
 		return 0, err
 	}
 	if !end(input[n:]) {
-		if c := input[n]; c == '*' || c == '+' {
+		if c := input[n]; c == '*' || c == '+' || c == '?' {
 			if !end(input[n+1:]) {
-				if d := input[n+1]; d == '*' || d == '+' {
-					return n, errDoubleClosure
+				if d := input[n+1]; d == '*' || d == '+' || d == '?' {
+					at := w.at(input[n+1:])
+					return n, w.errorf(ErrNestedRepeat, Span{at, at + 1}, at, "double closures not allowed at position %d", at)
 				}
 			}
 			w.WriteRune(c)
@@ -97,9 +111,9 @@ func closedSieve(input []rune, w *strings.Builder) (int, error) {
 	return n, nil
 }
 
-func basicSieve(input []rune, w *strings.Builder) (int, error) {
+func basicSieve(input []rune, w *translation) (int, error) {
 	// ε is permissible
-	if end(input) {
+	if end(input) || input[0] == '|' {
 		return 0, nil
 	}
 	if input[0] == '(' {
@@ -108,21 +122,47 @@ func basicSieve(input []rune, w *strings.Builder) (int, error) {
 		if err != nil {
 			return 1, err
 		}
-		if input[n+1] != ')' {
-			return 0, fmt.Errorf("bracket not closed")
+		if err := closeBracket(input, n, w); err != nil {
+			return 0, err
 		}
 		w.WriteRune(')')
 		return n + 2, nil
 	}
-	return 1, symbol(input[0], w)
+	return 1, symbol(input, w)
+}
+
+// closeBracket checks that the bracket at the start of input, followed by n
+// runes of an expression, is closed.
+func closeBracket(input []rune, n int, w *translation) error {
+	if 1+n == len(input) || input[1+n] != ')' {
+		at := w.at(input)
+		return w.errorf(ErrMissingParen, Span{at, at + len(input)}, at+1+n, "bracket opened at position %d not closed", at).expecting(")")
+	}
+	return nil
 }
 
-func symbol(c rune, w *strings.Builder) error {
-	if ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+// symbol writes the symbol at the start of input, which may not be the '⋅'
+// that Sieve writes between symbols.
+func symbol(input []rune, w *translation) error {
+	if c := input[0]; (isSymbol(c) && c != '⋅') || c == '.' {
 		w.WriteRune(c)
 		return nil
 	}
-	return fmt.Errorf("%q is not an allowed symbol", c)
+	at := w.at(input)
+	return w.errorf(ErrInvalidSymbol, Span{at, at + 1}, at, "%q at position %d is not an allowed symbol", input[0], at)
+}
+
+// translate runs the translation of the whole pattern by expr.
+func translate(regex string, expr ntparser) (string, error) {
+	w := translation{pattern: []rune(regex)}
+	n, err := expr(w.pattern, &w)
+	if err != nil {
+		return "", err
+	}
+	if n < len(w.pattern) {
+		return "", w.errorf(ErrUnexpectedParen, Span{n, n + 1}, n, "unmatched ')' at position %d", n)
+	}
+	return w.String(), nil
 }
 
 /*
@@ -139,18 +179,15 @@ We make use of the following language-and-translation scheme:
 
     closed → basic *  { print('*') }
            | basic +  { print('+') }
+           | basic ?  { print('?') }
            | basic
 
     basic  → ( expr )
            | symbol   { print(symbol) }
            | ε
 
-    symbol → a-Z | A-Z | 0-9
+    symbol → a-Z | A-Z | 0-9 | . | any non-ASCII character but ⋅
 */
 func Sieve(regex string) (string, error) {
-	var buf strings.Builder
-	if _, err := exprSieve([]rune(regex), &buf); err != nil {
-		return "", fmt.Errorf("%s: partial result %q", err, buf.String())
-	}
-	return buf.String(), nil
+	return translate(regex, exprSieve)
 }
@@ -1,6 +1,9 @@
 package compiler
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestSieveConvert(t *testing.T) {
 	cases := map[string]string{
@@ -10,6 +13,8 @@ func TestSieveConvert(t *testing.T) {
 		"(ab)|(cd)":          "(a⋅b)|(c⋅d)",
 		"andrew|jackson":     "a⋅n⋅d⋅r⋅e⋅w|j⋅a⋅c⋅k⋅s⋅o⋅n",
 		"(andrew)|(jackson)": "(a⋅n⋅d⋅r⋅e⋅w)|(j⋅a⋅c⋅k⋅s⋅o⋅n)",
+		"colou?r.":           "c⋅o⋅l⋅o⋅u?⋅r⋅.",
+		"café|日本":            "c⋅a⋅f⋅é|日⋅本",
 	}
 	for r, rpn := range cases {
 		out, err := Sieve(r)
@@ -21,3 +26,35 @@ func TestSieveConvert(t *testing.T) {
 		}
 	}
 }
+
+func TestSieveErrors(t *testing.T) {
+	for _, r := range sieveErrors {
+		if _, err := Sieve(r); !errors.As(err, new(*ParseError)) {
+			t.Fatalf("%q: expected a ParseError got %v", r, err)
+		}
+	}
+}
+
+// sieveErrors are rejected by Sieve.
+var sieveErrors = []string{"a⋅b", "a-b", "(", "a(", "(ab", "a)", "(a))", "a**", "*a", "(a|b"}
+
+// FuzzSieve checks that no pattern makes Sieve or RPNConvert panic, and that
+// RPNConvert accepts whatever Sieve writes.
+func FuzzSieve(f *testing.F) {
+	for _, r := range sieveErrors {
+		f.Add(r)
+	}
+	for _, r := range []string{"a(b|c)*d", "(ab)|(cd)", "colou?r.", "café|日本", "(a|)*", "a⋅(b|c)*⋅d"} {
+		f.Add(r)
+	}
+	f.Fuzz(func(t *testing.T, r string) {
+		RPNConvert(r)
+		out, err := Sieve(r)
+		if err != nil {
+			return
+		}
+		if _, err := RPNConvert(out); err != nil {
+			t.Fatalf("%q: cannot convert %q: %v", r, out, err)
+		}
+	})
+}
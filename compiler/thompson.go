@@ -0,0 +1,152 @@
+package compiler
+
+import (
+	"fmt"
+	"unicode"
+
+	"thompson-regex/nfa"
+)
+
+// NFA returns the automaton for the syntax tree of a regex, built by
+// Thompson's construction.
+func NFA(root Node) (*nfa.NFA, error) {
+	longest, ok := root.(*Longest)
+	if ok {
+		root = longest.Sub
+	}
+	var b nfa.Builder
+	f, err := thompson(&b, root)
+	if err != nil {
+		return nil, err
+	}
+	n := b.Finish(f)
+	n.Longest = ok
+	return n, nil
+}
+
+func thompson(b *nfa.Builder, root Node) (nfa.Frag, error) {
+	switch n := root.(type) {
+	case *Literal:
+		return b.Rune(n.Rune), nil
+	case *Class:
+		if n.Negated {
+			return b.Class(negate(n.Ranges)), nil
+		}
+		return b.Class(n.Ranges), nil
+	case *Any:
+		if n.NL {
+			return b.Class([]rune{0, unicode.MaxRune}), nil
+		}
+		return b.Class([]rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}), nil
+	case *Assert:
+		return b.Assert(map[Anchor]nfa.Anchor{
+			BeginText: nfa.BeginText,
+			EndText:   nfa.EndText,
+			BeginLine: nfa.BeginLine,
+			EndLine:   nfa.EndLine,
+		}[n.Anchor]), nil
+	case *Concat:
+		x, y, err := thompsonPair(b, n.A, n.B)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		return b.Concat(x, y), nil
+	case *Alternate:
+		x, y, err := thompsonPair(b, n.A, n.B)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		return b.Or(x, y), nil
+	case *Intersect, *Complement:
+		d, err := automaton(n)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		trans, accepting := transitions(d)
+		return b.DFA(d.Start, trans, accepting), nil
+	case *Star:
+		x, err := thompson(b, n.Sub)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		return b.Star(x, n.Lazy), nil
+	case *Plus:
+		x, err := thompson(b, n.Sub)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		return b.Plus(x, n.Lazy), nil
+	case *Quest:
+		x, err := thompson(b, n.Sub)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		return b.Quest(x, n.Lazy), nil
+	case *Repeat:
+		return thompsonRepeat(b, n)
+	case *Group:
+		x, err := thompson(b, n.Sub)
+		if err != nil || n.Index == 0 {
+			return x, err
+		}
+		return b.Capture(x, n.Index), nil
+	case *Empty:
+		return b.Empty(), nil
+	case *NoMatch:
+		return b.Fail(), nil
+	case *Longest:
+		return nfa.Frag{}, fmt.Errorf("longest match at position %d below the root", n.Start)
+	}
+	return nfa.Frag{}, fmt.Errorf("unknown node %T", root)
+}
+
+func thompsonPair(b *nfa.Builder, x, y Node) (nfa.Frag, nfa.Frag, error) {
+	xf, err := thompson(b, x)
+	if err != nil {
+		return nfa.Frag{}, nfa.Frag{}, err
+	}
+	yf, err := thompson(b, y)
+	if err != nil {
+		return nfa.Frag{}, nfa.Frag{}, err
+	}
+	return xf, yf, nil
+}
+
+// thompsonRepeat unrolls the repetition as Go's regexp does: x{2,} is built as
+// xx+, and x{2,4} as xx(x(x)?)?.
+func thompsonRepeat(b *nfa.Builder, n *Repeat) (nfa.Frag, error) {
+	f := b.Empty()
+	mandatory := n.Min
+	if n.Max < 0 && n.Min > 0 {
+		mandatory--
+	}
+	for i := 0; i < mandatory; i++ {
+		x, err := thompson(b, n.Sub)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		f = b.Concat(f, x)
+	}
+	if n.Max < 0 {
+		x, err := thompson(b, n.Sub)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		if n.Min > 0 {
+			return b.Concat(f, b.Plus(x, n.Lazy)), nil
+		}
+		return b.Concat(f, b.Star(x, n.Lazy)), nil
+	}
+	if n.Max == n.Min {
+		return f, nil
+	}
+	tail := b.Empty()
+	for i := n.Min; i < n.Max; i++ {
+		x, err := thompson(b, n.Sub)
+		if err != nil {
+			return nfa.Frag{}, err
+		}
+		tail = b.Quest(b.Concat(x, tail), n.Lazy)
+	}
+	return b.Concat(f, tail), nil
+}
@@ -0,0 +1,31 @@
+package compiler
+
+import "testing"
+
+func TestNFA(t *testing.T) {
+	tree, err := ParseTree("a(b|c)*d", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NFA(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := `start 0, accept 10
+groups 1
+0: 'a' → 8
+1: 'b' → 4
+2: 'c' → 4
+3: ε → 1, 2
+4: ε → 6
+5: save 2 → 3
+6: save 3 → 8
+7: ε → 9
+8: ε → 5, 7
+9: 'd' → 10
+10: match
+`
+	if out := n.String(); out != dump {
+		t.Fatalf("expected\n%s\ngot\n%s", dump, out)
+	}
+}
@@ -0,0 +1,128 @@
+// Package dfa turns the nondeterministic automata of package nfa into
+// deterministic ones, which need a single table lookup per input rune.
+package dfa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Dead is the state from which no accepting state can be reached. Every DFA
+// has it, and runes that the automaton does not expect lead to it.
+const Dead = 0
+
+// A DFA is a deterministic finite automaton over classes of runes. The runes
+// in a class are indistinguishable to the automaton.
+type DFA struct {
+	// Bounds partitions the runes into classes: class i holds the runes from
+	// Bounds[i] up to but not including Bounds[i+1], and the last class
+	// holds the rest. Bounds[0] is always 0.
+	Bounds []rune
+	// Trans[s][c] is the state reached from state s on a rune of class c.
+	Trans [][]int
+	// Accepting[s] reports whether s is an accepting state.
+	Accepting []bool
+	Start     int
+}
+
+// class returns the class of the rune r.
+func (d *DFA) class(r rune) int {
+	return classOf(d.Bounds, r)
+}
+
+// classOf returns the class of the rune r in the partition given by bounds.
+func classOf(bounds []rune, r rune) int {
+	return sort.Search(len(bounds), func(i int) bool { return bounds[i] > r }) - 1
+}
+
+// Accepts reports whether the automaton is in an accepting state after
+// reading all of s.
+func (d *DFA) Accepts(s string) bool {
+	state := d.Start
+	for _, r := range s {
+		if state = d.Trans[state][d.class(r)]; state == Dead {
+			return false
+		}
+	}
+	return d.Accepting[state]
+}
+
+// AcceptsPrefix reports whether the automaton passes through an accepting
+// state while reading s, which it stops doing as soon as it does.
+func (d *DFA) AcceptsPrefix(s string) bool {
+	state := d.Start
+	for _, r := range s {
+		if d.Accepting[state] {
+			return true
+		}
+		if state = d.Trans[state][d.class(r)]; state == Dead {
+			return false
+		}
+	}
+	return d.Accepting[state]
+}
+
+// NumStates returns the number of states, including the dead state.
+func (d *DFA) NumStates() int {
+	return len(d.Trans)
+}
+
+// classString describes the runes of class c.
+func (d *DFA) classString(c int) string {
+	lo := d.Bounds[c]
+	hi := rune(utf8.MaxRune)
+	if c+1 < len(d.Bounds) {
+		hi = d.Bounds[c+1] - 1
+	}
+	if lo == hi {
+		return fmt.Sprintf("%q", lo)
+	}
+	return fmt.Sprintf("[%q-%q]", lo, hi)
+}
+
+// Ranges returns the runes on which state s moves to state t, as pairs of
+// inclusive bounds in order.
+func (d *DFA) Ranges(s, t int) []rune {
+	var ranges []rune
+	for c, next := range d.Trans[s] {
+		if next != t {
+			continue
+		}
+		lo, hi := d.Bounds[c], rune(utf8.MaxRune)
+		if c+1 < len(d.Bounds) {
+			hi = d.Bounds[c+1] - 1
+		}
+		if n := len(ranges); n > 0 && ranges[n-1]+1 == lo {
+			ranges[n-1] = hi
+		} else {
+			ranges = append(ranges, lo, hi)
+		}
+	}
+	return ranges
+}
+
+func (d *DFA) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "start %d\n", d.Start)
+	for s := range d.Trans {
+		if s == Dead {
+			continue
+		}
+		fmt.Fprintf(&b, "%d", s)
+		if d.Accepting[s] {
+			b.WriteString(" (accept)")
+		}
+		b.WriteString(":")
+		sep := " "
+		for c, t := range d.Trans[s] {
+			if t != Dead {
+				fmt.Fprintf(&b, "%s%s → %d", sep, d.classString(c), t)
+				sep = ", "
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,188 @@
+package dfa
+
+import (
+	"sync"
+
+	"thompson-regex/nfa"
+)
+
+// DefaultCacheBytes is the cache budget used when NewLazy is given zero.
+const DefaultCacheBytes = 1 << 20
+
+const (
+	// A flush is deemed wasteful if fewer runes than this were read per
+	// cached state since the previous one.
+	minRunesPerState = 10
+	// After this many wasteful flushes in one search the cache is thrashing
+	// and the search falls back to simulating the NFA.
+	maxWastefulFlushes = 3
+)
+
+// A lazyState is a state of the lazy DFA. Its transitions are filled in as
+// they are first taken, with nil for those not yet known.
+type lazyState struct {
+	set       []int
+	accepting bool
+	next      []*lazyState
+}
+
+// LazyStats counts the work done by a Lazy.
+type LazyStats struct {
+	// States is the number of states built since the cache was created.
+	States int
+	// CacheBytes is the estimated size of the states now in the cache.
+	CacheBytes int
+	// Flushes counts the times the cache filled up and was emptied.
+	Flushes int
+	// Fallbacks counts the searches handed to the NFA because the cache
+	// was thrashing or the NFA has assertions.
+	Fallbacks int
+}
+
+// A Lazy is an unanchored DFA whose states are built by subset construction
+// only when the input reaches them. Built states are cached until they exceed
+// a memory budget, when the cache is emptied. An NFA with assertions is
+// simulated directly instead. It is safe for concurrent use.
+type Lazy struct {
+	nfa     *nfa.NFA
+	asserts bool
+	bounds  []rune
+	budget  int
+
+	mu    sync.RWMutex
+	cache map[string]*lazyState
+	start *lazyState
+	stats LazyStats
+}
+
+// NewLazy returns a lazy DFA for the NFA whose cache holds about cacheBytes
+// bytes of states.
+func NewLazy(n *nfa.NFA, cacheBytes int) *Lazy {
+	if cacheBytes == 0 {
+		cacheBytes = DefaultCacheBytes
+	}
+	return &Lazy{
+		nfa:     n,
+		asserts: n.HasAssertions(),
+		bounds:  partition(n),
+		budget:  cacheBytes,
+		cache:   map[string]*lazyState{},
+	}
+}
+
+// Stats returns the counts of the work done so far.
+func (l *Lazy) Stats() LazyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// size estimates the memory taken by a state over the given set.
+func (l *Lazy) size(set []int) int {
+	const word, overhead = 8, 64
+	return word*(len(set)+len(l.bounds)) + overhead
+}
+
+func (l *Lazy) flush() {
+	l.cache = map[string]*lazyState{}
+	l.start = nil
+	l.stats.CacheBytes = 0
+	l.stats.Flushes++
+}
+
+// state returns the cached state for the set, building it if need be.
+func (l *Lazy) state(set []int) *lazyState {
+	key := setKey(set)
+	if st, ok := l.cache[key]; ok {
+		return st
+	}
+	size := l.size(set)
+	if l.stats.CacheBytes+size > l.budget {
+		l.flush()
+	}
+	st := &lazyState{set, contains(set, l.nfa.Accept), make([]*lazyState, len(l.bounds))}
+	l.cache[key] = st
+	l.stats.CacheBytes += size
+	l.stats.States++
+	return st
+}
+
+func (l *Lazy) startState() *lazyState {
+	if l.start == nil {
+		l.start = l.state(closure(l.nfa, []int{l.nfa.Start}))
+	}
+	return l.start
+}
+
+// step returns the state reached from st on a rune of class c, with the
+// number of states built to reach it and whether the cache was flushed to make
+// room for them. A new match may begin after every rune, so the NFA's start
+// state is always included. Transitions already taken are followed under the
+// read lock, and only new ones take the write lock.
+func (l *Lazy) step(st *lazyState, c int) (next *lazyState, built int, flushed bool) {
+	l.mu.RLock()
+	next = st.next[c]
+	l.mu.RUnlock()
+	if next != nil {
+		return next, 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// another search may have taken the transition meanwhile
+	if next = st.next[c]; next != nil {
+		return next, 0, false
+	}
+	moves := []int{l.nfa.Start}
+	for _, q := range st.set {
+		if s := &l.nfa.States[q]; s.Consumes(l.bounds[c]) {
+			moves = append(moves, s.Out)
+		}
+	}
+	states, flushes := l.stats.States, l.stats.Flushes
+	next = l.state(closure(l.nfa, moves))
+	// after a flush st is no longer cached, but linking it is harmless
+	st.next[c] = next
+	return next, l.stats.States - states, l.stats.Flushes != flushes
+}
+
+// fallback records a search handed to the NFA.
+func (l *Lazy) fallback() {
+	l.mu.Lock()
+	l.stats.Fallbacks++
+	l.mu.Unlock()
+}
+
+// Match reports whether s contains a match of the NFA. The lock is held only
+// while states are looked up and built, so that searches run concurrently,
+// and never while the NFA is simulated.
+func (l *Lazy) Match(s string) bool {
+	if l.asserts {
+		l.fallback()
+		return l.nfa.Match(s)
+	}
+	l.mu.Lock()
+	st := l.startState()
+	l.mu.Unlock()
+	read, built, wasteful := 0, 0, 0
+	for _, r := range s {
+		if st.accepting {
+			return true
+		}
+		var n int
+		var flushed bool
+		st, n, flushed = l.step(st, classOf(l.bounds, r))
+		read++
+		built += n
+		if !flushed {
+			continue
+		}
+		if read < minRunesPerState*built {
+			if wasteful++; wasteful >= maxWastefulFlushes {
+				l.fallback()
+				return l.nfa.Match(s)
+			}
+		}
+		read, built = 0, 0
+	}
+	return st.accepting
+}
@@ -0,0 +1,78 @@
+package dfa_test
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"thompson-regex/dfa"
+)
+
+// randomInput returns n runes drawn from the alphabet.
+func randomInput(r *rand.Rand, alphabet string, n int) string {
+	runes := []rune(alphabet)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteRune(runes[r.Intn(len(runes))])
+	}
+	return b.String()
+}
+
+func TestLazy(t *testing.T) {
+	// the full DFA would have 2¹⁶ states
+	expr := "(a|b)*a" + strings.Repeat("(a|b)", 15) + "c"
+	std := regexp.MustCompile(expr)
+	r := rand.New(rand.NewSource(1))
+	for _, budget := range []int{0, 1 << 14} {
+		l := dfa.NewLazy(compile(t, expr), budget)
+		for i := 0; i < 50; i++ {
+			input := randomInput(r, "ab", 500) + randomInput(r, "abc", 20)
+			if got, want := l.Match(input), std.MatchString(input); got != want {
+				t.Fatalf("budget %d on %q: expected %v got %v", budget, input, want, got)
+			}
+			if stats := l.Stats(); budget > 0 && stats.CacheBytes > budget {
+				t.Fatalf("budget %d: cache grew to %d bytes", budget, stats.CacheBytes)
+			}
+		}
+		if stats := l.Stats(); budget > 0 && stats.Flushes == 0 {
+			t.Fatalf("budget %d: expected the cache to be flushed", budget)
+		}
+	}
+}
+
+func TestLazyFallback(t *testing.T) {
+	expr := "(a|b)*a" + strings.Repeat("(a|b)", 15) + "c"
+	std := regexp.MustCompile(expr)
+	l := dfa.NewLazy(compile(t, expr), 1<<10)
+	input := randomInput(rand.New(rand.NewSource(2)), "ab", 2000) + "c"
+	if got, want := l.Match(input), std.MatchString(input); got != want {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+	if stats := l.Stats(); stats.Fallbacks != 1 {
+		t.Fatalf("expected a fallback to the NFA, got %+v", stats)
+	}
+}
+
+func TestLazyConcurrent(t *testing.T) {
+	expr := "(a|b)*a" + strings.Repeat("(a|b)", 10) + "c"
+	std := regexp.MustCompile(expr)
+	l := dfa.NewLazy(compile(t, expr), 1<<14)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 20; i++ {
+				input := randomInput(r, "ab", 300) + randomInput(r, "abc", 20)
+				if got, want := l.Match(input), std.MatchString(input); got != want {
+					t.Errorf("on %q: expected %v got %v", input, want, got)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,240 @@
+package dfa
+
+// Minimize returns the DFA with the fewest states that accepts the same
+// language, found by Hopcroft's partition refinement.
+//
+// The result is canonical: adjacent classes of runes on which every state
+// behaves alike are merged, the dead state is numbered Dead and the others
+// are numbered in the order a breadth-first search from the start state
+// reaches them, trying classes in order. Two automata therefore accept the
+// same language exactly when their minimizations are Equal.
+func (d *DFA) Minimize() *DFA {
+	reachable := d.reachable()
+
+	// block[s] is the block of the partition containing state s, with -1
+	// for unreachable states, which are ignored. The dead state lies in the
+	// block of every state that cannot reach an accepting one.
+	block := make([]int, d.NumStates())
+	var blocks [][]int
+	accepting, rejecting := []int{}, []int{}
+	for s := range d.Trans {
+		switch {
+		case !reachable[s]:
+			block[s] = -1
+		case d.Accepting[s]:
+			accepting = append(accepting, s)
+		default:
+			rejecting = append(rejecting, s)
+		}
+	}
+	for _, b := range [][]int{rejecting, accepting} {
+		if len(b) > 0 {
+			for _, s := range b {
+				block[s] = len(blocks)
+			}
+			blocks = append(blocks, b)
+		}
+	}
+
+	// inverse[c][t] lists the states that move to t on class c.
+	inverse := make([][][]int, len(d.Bounds))
+	for c := range inverse {
+		inverse[c] = make([][]int, d.NumStates())
+	}
+	for s, row := range d.Trans {
+		if reachable[s] {
+			for c, t := range row {
+				inverse[c][t] = append(inverse[c][t], s)
+			}
+		}
+	}
+
+	// Each splitter in the worklist is a block, used with every class.
+	work := []int{}
+	inWork := make([]bool, len(blocks), d.NumStates())
+	if len(blocks) == 2 {
+		smaller := 0
+		if len(blocks[1]) < len(blocks[0]) {
+			smaller = 1
+		}
+		work = append(work, smaller)
+		inWork[smaller] = true
+	}
+	for len(work) > 0 {
+		a := work[len(work)-1]
+		work = work[:len(work)-1]
+		inWork[a] = false
+		splitter := append([]int{}, blocks[a]...)
+		for c := range d.Bounds {
+			// the states that move into the splitter on c, by block
+			marked := map[int][]int{}
+			for _, t := range splitter {
+				for _, s := range inverse[c][t] {
+					marked[block[s]] = append(marked[block[s]], s)
+				}
+			}
+			for y, in := range marked {
+				if len(in) == len(blocks[y]) {
+					continue
+				}
+				isIn := map[int]bool{}
+				for _, s := range in {
+					isIn[s] = true
+				}
+				out := []int{}
+				for _, s := range blocks[y] {
+					if !isIn[s] {
+						out = append(out, s)
+					}
+				}
+				z := len(blocks)
+				blocks[y] = out
+				blocks = append(blocks, in)
+				inWork = append(inWork, false)
+				for _, s := range in {
+					block[s] = z
+				}
+				switch {
+				case inWork[y]:
+					work = append(work, z)
+					inWork[z] = true
+				case len(in) < len(out):
+					work = append(work, z)
+					inWork[z] = true
+				default:
+					work = append(work, y)
+					inWork[y] = true
+				}
+			}
+		}
+	}
+	return d.quotient(block)
+}
+
+// reachable returns the states that can be reached from the start state.
+func (d *DFA) reachable() []bool {
+	seen := make([]bool, d.NumStates())
+	seen[d.Start] = true
+	queue := []int{d.Start}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, t := range d.Trans[s] {
+			if !seen[t] {
+				seen[t] = true
+				queue = append(queue, t)
+			}
+		}
+	}
+	return seen
+}
+
+// quotient returns the canonical automaton whose states are the blocks of
+// the given partition.
+func (d *DFA) quotient(block []int) *DFA {
+	// merge adjacent classes on which every reachable state behaves alike
+	var classes []int
+	for c := range d.Bounds {
+		if c > 0 && sameColumn(d, block, c-1, c) {
+			continue
+		}
+		classes = append(classes, c)
+	}
+	m := &DFA{}
+	for _, c := range classes {
+		m.Bounds = append(m.Bounds, d.Bounds[c])
+	}
+
+	// number the blocks breadth first, with the dead one first
+	number := map[int]int{}
+	var order []int
+	// the dead block is the rejecting one that cannot be left
+	leaves := map[int]bool{}
+	for s, b := range block {
+		if b < 0 {
+			continue
+		}
+		if d.Accepting[s] {
+			leaves[b] = true
+		}
+		for _, t := range d.Trans[s] {
+			if block[t] != b {
+				leaves[b] = true
+			}
+		}
+	}
+	dead := -1
+	for _, b := range block {
+		if b >= 0 && !leaves[b] {
+			dead = b
+			break
+		}
+	}
+	number[dead] = Dead
+	order = append(order, dead)
+	representative := map[int]int{}
+	for s := len(block) - 1; s >= 0; s-- {
+		if block[s] >= 0 {
+			representative[block[s]] = s
+		}
+	}
+	if _, ok := number[block[d.Start]]; !ok {
+		number[block[d.Start]] = len(order)
+		order = append(order, block[d.Start])
+	}
+	for i := 0; i < len(order); i++ {
+		b := order[i]
+		row := make([]int, len(classes))
+		accepting := false
+		if s, ok := representative[b]; ok {
+			accepting = d.Accepting[s]
+			for j, c := range classes {
+				t := block[d.Trans[s][c]]
+				if _, ok := number[t]; !ok {
+					number[t] = len(order)
+					order = append(order, t)
+				}
+				row[j] = number[t]
+			}
+		}
+		m.Trans = append(m.Trans, row)
+		m.Accepting = append(m.Accepting, accepting)
+	}
+	m.Start = number[block[d.Start]]
+	return m
+}
+
+// sameColumn reports whether classes c and e lead every reachable state to
+// the same block.
+func sameColumn(d *DFA, block []int, c, e int) bool {
+	for s, row := range d.Trans {
+		if block[s] >= 0 && block[row[c]] != block[row[e]] {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal reports whether the automata have identical states and transitions.
+// Minimized automata are Equal exactly when they accept the same language.
+func (d *DFA) Equal(e *DFA) bool {
+	if d.Start != e.Start || len(d.Bounds) != len(e.Bounds) || len(d.Trans) != len(e.Trans) {
+		return false
+	}
+	for i := range d.Bounds {
+		if d.Bounds[i] != e.Bounds[i] {
+			return false
+		}
+	}
+	for s := range d.Trans {
+		if d.Accepting[s] != e.Accepting[s] {
+			return false
+		}
+		for c := range d.Trans[s] {
+			if d.Trans[s][c] != e.Trans[s][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
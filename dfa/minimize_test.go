@@ -0,0 +1,54 @@
+package dfa_test
+
+import (
+	"testing"
+
+	"thompson-regex/dfa"
+)
+
+func minimal(t *testing.T, expr string) *dfa.DFA {
+	d, err := dfa.New(compile(t, expr), dfa.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.Minimize()
+}
+
+func TestMinimize(t *testing.T) {
+	cases := map[string]int{
+		"(a|b)*":       2,
+		"(a|b)*abb":    5,
+		"a(b|c)*d":     4,
+		"(ab|ba)*":     4,
+		"andrew|andre": 8,
+	}
+	for expr, states := range cases {
+		if n := minimal(t, expr).NumStates(); n != states {
+			t.Fatalf("%q: expected %d states got %d", expr, states, n)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	equivalent := [][2]string{
+		{"(a|b)*", "(a*b*)*"},
+		{"a*a", "a+"},
+		{"(a|b)*abb", "(b|a)*ab(b)"},
+		{"a(b|c)*d", "a(b*c*)*d"},
+	}
+	for _, pair := range equivalent {
+		if !minimal(t, pair[0]).Equal(minimal(t, pair[1])) {
+			t.Fatalf("%q and %q: expected equal", pair[0], pair[1])
+		}
+	}
+	distinct := [][2]string{
+		{"(a|b)*", "(a|b)+"},
+		{"a*", "b*"},
+		{"a(b|c)*d", "a(b|c)+d"},
+	}
+	for _, pair := range distinct {
+		if minimal(t, pair[0]).Equal(minimal(t, pair[1])) {
+			t.Fatalf("%q and %q: expected distinct", pair[0], pair[1])
+		}
+	}
+}
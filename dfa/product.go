@@ -0,0 +1,86 @@
+package dfa
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Intersect returns the product automaton of d and e, which accepts the
+// strings that both accept. Each of its states is a pair of states of d and
+// e, reached from the pair of their start states by moving both on the same
+// runes; only the pairs so reached are built, up to maxStates of them
+// including the dead state, or DefaultMaxStates if maxStates is zero.
+func Intersect(d, e *DFA, maxStates int) (*DFA, error) {
+	limit := maxStates
+	if limit == 0 {
+		limit = DefaultMaxStates
+	}
+	p := &DFA{Bounds: mergeBounds(d.Bounds, e.Bounds)}
+	pairs := [][2]int{{Dead, Dead}}
+	ids := map[[2]int]int{{Dead, Dead}: Dead}
+	add := func(pair [2]int) (int, error) {
+		if pair[0] == Dead || pair[1] == Dead {
+			return Dead, nil
+		}
+		if id, ok := ids[pair]; ok {
+			return id, nil
+		}
+		if len(pairs) >= limit {
+			return 0, fmt.Errorf("%w: more than %d", ErrStateLimit, limit)
+		}
+		ids[pair] = len(pairs)
+		pairs = append(pairs, pair)
+		return len(pairs) - 1, nil
+	}
+	start, err := add([2]int{d.Start, e.Start})
+	if err != nil {
+		return nil, err
+	}
+	p.Start = start
+	for s := 0; s < len(pairs); s++ {
+		pair := pairs[s]
+		row := make([]int, len(p.Bounds))
+		for c, r := range p.Bounds {
+			if row[c], err = add([2]int{d.Trans[pair[0]][d.class(r)], e.Trans[pair[1]][e.class(r)]}); err != nil {
+				return nil, err
+			}
+		}
+		p.Trans = append(p.Trans, row)
+		p.Accepting = append(p.Accepting, d.Accepting[pair[0]] && e.Accepting[pair[1]])
+	}
+	return p, nil
+}
+
+// Complement returns the automaton accepting the strings that d rejects. Its
+// states are those of d, numbered one higher, with acceptance reversed, so
+// that the dead state of d accepts every string that strays from d; the
+// new dead state cannot be reached.
+func (d *DFA) Complement() *DFA {
+	c := &DFA{Bounds: d.Bounds, Start: d.Start + 1}
+	c.Trans = append(c.Trans, make([]int, len(d.Bounds)))
+	c.Accepting = append(c.Accepting, false)
+	for s, row := range d.Trans {
+		shifted := make([]int, len(row))
+		for i, t := range row {
+			shifted[i] = t + 1
+		}
+		c.Trans = append(c.Trans, shifted)
+		c.Accepting = append(c.Accepting, !d.Accepting[s])
+	}
+	return c
+}
+
+// mergeBounds returns the bounds of the coarsest partition of the runes that
+// refines both partitions given by their bounds.
+func mergeBounds(a, b []rune) []rune {
+	seen := map[rune]bool{}
+	var bounds []rune
+	for _, r := range append(append([]rune{}, a...), b...) {
+		if !seen[r] {
+			seen[r] = true
+			bounds = append(bounds, r)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return bounds
+}
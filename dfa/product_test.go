@@ -0,0 +1,56 @@
+package dfa_test
+
+import (
+	"errors"
+	"testing"
+
+	"thompson-regex/dfa"
+)
+
+func TestIntersect(t *testing.T) {
+	cases := []struct {
+		a, b, want string
+	}{
+		{"(a|b)*", "a*", "a*"},
+		{"(a|b)*a", "(a|b)*b", `[^\x00-\x{10FFFF}]`},
+		{"[a-z]+", "(if|for|func)", "if|for|func"},
+		{"(aa)*", "(aaa)*", "(a{6})*"},
+		{"[a-m]*", "[h-z]*", "[h-m]*"},
+	}
+	for _, c := range cases {
+		got, err := dfa.Intersect(minimal(t, c.a), minimal(t, c.b), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Minimize().Equal(minimal(t, c.want)) {
+			t.Fatalf("%q & %q: expected the language of %q, got\n%v", c.a, c.b, c.want, got.Minimize())
+		}
+	}
+}
+
+func TestIntersectLimit(t *testing.T) {
+	_, err := dfa.Intersect(minimal(t, "(a{7})*"), minimal(t, "(a{11})*"), 20)
+	if !errors.Is(err, dfa.ErrStateLimit) {
+		t.Fatalf("expected the state limit, got %v", err)
+	}
+}
+
+func TestComplement(t *testing.T) {
+	d := minimal(t, "if|for|func").Complement()
+	for _, s := range []string{"", "i", "fo", "iff", "fun", "while", "forfunc"} {
+		if !d.Accepts(s) {
+			t.Fatalf("expected %q accepted", s)
+		}
+	}
+	for _, s := range []string{"if", "for", "func"} {
+		if d.Accepts(s) {
+			t.Fatalf("expected %q rejected", s)
+		}
+	}
+	if !d.Complement().Minimize().Equal(minimal(t, "if|for|func")) {
+		t.Fatal("expected the complement of the complement to be the original")
+	}
+	if !minimal(t, "(a|b)*").Complement().Minimize().Equal(minimal(t, `(a|b)*[^ab][\x00-\x{10FFFF}]*`)) {
+		t.Fatal("expected the complement of (a|b)* to hold the strings with another rune")
+	}
+}
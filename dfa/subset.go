@@ -0,0 +1,162 @@
+package dfa
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"thompson-regex/nfa"
+)
+
+// DefaultMaxStates is the state limit used when Config.MaxStates is zero.
+const DefaultMaxStates = 10000
+
+// ErrStateLimit is returned (wrapped) when an automaton would need more
+// states than allowed.
+var ErrStateLimit = errors.New("too many DFA states")
+
+// ErrAssertions is returned when the NFA has Assert states, which depend on
+// the position in the input and so have no place in a DFA built here.
+var ErrAssertions = errors.New("DFA cannot check assertions")
+
+// A Config controls subset construction.
+type Config struct {
+	// MaxStates bounds the number of states of the automaton, including the
+	// dead state.
+	MaxStates int
+	// Unanchored builds an automaton that may begin matching at any point of
+	// its input, so that it passes through an accepting state wherever a
+	// match of the NFA ends.
+	Unanchored bool
+}
+
+// New builds the DFA equivalent to the NFA by subset construction: each state
+// of the DFA is the set of NFA states the simulation could be in.
+func New(n *nfa.NFA, conf Config) (*DFA, error) {
+	if n.HasAssertions() {
+		return nil, ErrAssertions
+	}
+	limit := conf.MaxStates
+	if limit == 0 {
+		limit = DefaultMaxStates
+	}
+	d := &DFA{Bounds: partition(n)}
+	sets := [][]int{nil}
+	ids := map[string]int{}
+	add := func(set []int) (int, error) {
+		if len(set) == 0 {
+			return Dead, nil
+		}
+		key := setKey(set)
+		if id, ok := ids[key]; ok {
+			return id, nil
+		}
+		if len(sets) >= limit {
+			return 0, fmt.Errorf("%w: more than %d", ErrStateLimit, limit)
+		}
+		ids[key] = len(sets)
+		sets = append(sets, set)
+		return len(sets) - 1, nil
+	}
+	start, err := add(closure(n, []int{n.Start}))
+	if err != nil {
+		return nil, err
+	}
+	d.Start = start
+	for s := 0; s < len(sets); s++ {
+		moves := make([][]int, len(d.Bounds))
+		for _, q := range sets[s] {
+			st := &n.States[q]
+			for c, r := range d.Bounds {
+				if st.Consumes(r) {
+					moves[c] = append(moves[c], st.Out)
+				}
+			}
+		}
+		row := make([]int, len(d.Bounds))
+		for c, next := range moves {
+			if s != Dead && conf.Unanchored {
+				next = append(next, n.Start)
+			}
+			if row[c], err = add(closure(n, next)); err != nil {
+				return nil, err
+			}
+		}
+		d.Trans = append(d.Trans, row)
+		d.Accepting = append(d.Accepting, contains(sets[s], n.Accept))
+	}
+	return d, nil
+}
+
+// partition returns the bounds of the coarsest classes of runes that the
+// transitions of the NFA do not distinguish between.
+func partition(n *nfa.NFA) []rune {
+	seen := map[rune]bool{0: true}
+	bounds := []rune{0}
+	// no class begins beyond the last rune
+	add := func(r rune) {
+		if !seen[r] && r <= utf8.MaxRune {
+			seen[r] = true
+			bounds = append(bounds, r)
+		}
+	}
+	for _, st := range n.States {
+		switch st.Kind {
+		case nfa.Rune:
+			add(st.Rune)
+			add(st.Rune + 1)
+		case nfa.Class:
+			for i := 0; i < len(st.Ranges); i += 2 {
+				add(st.Ranges[i])
+				add(st.Ranges[i+1] + 1)
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return bounds
+}
+
+// closure returns the sorted set of states that consume input or accept which
+// are reachable from the given states by ε-transitions alone.
+func closure(n *nfa.NFA, states []int) []int {
+	seen := map[int]bool{}
+	var set []int
+	var visit func(int)
+	visit = func(q int) {
+		if q < 0 || seen[q] {
+			return
+		}
+		seen[q] = true
+		switch st := n.States[q]; st.Kind {
+		case nfa.Epsilon, nfa.Save:
+			visit(st.Out)
+		case nfa.Split:
+			visit(st.Out)
+			visit(st.Out1)
+		default:
+			set = append(set, q)
+		}
+	}
+	for _, q := range states {
+		visit(q)
+	}
+	sort.Ints(set)
+	return set
+}
+
+func setKey(set []int) string {
+	var b strings.Builder
+	for _, q := range set {
+		b.WriteString(strconv.Itoa(q))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+func contains(set []int, q int) bool {
+	i := sort.SearchInts(set, q)
+	return i < len(set) && set[i] == q
+}
@@ -0,0 +1,77 @@
+package dfa_test
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"thompson-regex/compiler"
+	"thompson-regex/dfa"
+	"thompson-regex/nfa"
+)
+
+func compile(t *testing.T, expr string) *nfa.NFA {
+	tree, err := compiler.ParseTree(expr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := compiler.NFA(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNew(t *testing.T) {
+	cases := map[string][]string{
+		"a(b|c)*d":  {"ad", "abcbd", "abc", "xad", ""},
+		"a*a":       {"", "a", "aaa", "ab"},
+		"(a|ab)c":   {"ac", "abc", "abbc"},
+		"(a|b)*abb": {"abb", "aababb", "abba"},
+	}
+	for expr, inputs := range cases {
+		n := compile(t, expr)
+		anchored, err := dfa.New(n, dfa.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unanchored, err := dfa.New(n, dfa.Config{Unanchored: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		full := regexp.MustCompile("^(?:" + expr + ")$")
+		std := regexp.MustCompile(expr)
+		for _, input := range inputs {
+			if got, want := anchored.Accepts(input), full.MatchString(input); got != want {
+				t.Fatalf("%q accepting %q: expected %v got %v", expr, input, want, got)
+			}
+			if got, want := unanchored.AcceptsPrefix(input), std.MatchString(input); got != want {
+				t.Fatalf("%q searching %q: expected %v got %v", expr, input, want, got)
+			}
+		}
+	}
+}
+
+func TestStateLimit(t *testing.T) {
+	// the DFA must remember the last ten runes, needing 2¹⁰ states
+	n := compile(t, "(a|b)*a"+strings.Repeat("(a|b)", 9))
+	if _, err := dfa.New(n, dfa.Config{MaxStates: 1000}); !errors.Is(err, dfa.ErrStateLimit) {
+		t.Fatalf("expected state limit error, got %v", err)
+	}
+	if _, err := dfa.New(n, dfa.Config{MaxStates: 2000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	if _, err := dfa.New(compile(t, "^ab$"), dfa.Config{}); !errors.Is(err, dfa.ErrAssertions) {
+		t.Fatalf("expected assertions error, got %v", err)
+	}
+	lazy := dfa.NewLazy(compile(t, "^ab$"), 0)
+	for input, want := range map[string]bool{"ab": true, "abb": false, "xab": false} {
+		if got := lazy.Match(input); got != want {
+			t.Fatalf("%q: expected %v got %v", input, want, got)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Package engine matches regular expressions in process by simulating their
+// Thompson NFA, so that callers need not generate and build a program.
+//
+// The API mirrors a subset of the standard regexp package, but patterns are
+// written in the dialect accepted by the compiler package, or for
+// CompilePOSIX in POSIX ERE.
+package engine
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"thompson-regex/compiler"
+	"thompson-regex/dfa"
+	"thompson-regex/nfa"
+)
+
+// An Engine selects how a Regexp decides whether its input matches. The
+// locations of matches are always found by simulating the NFA, as is every
+// match of an expression with anchors, which the DFAs cannot check; but the
+// DFAs first decide whether the input holds a match at all, so that the NFA
+// is only simulated over input where there is one to locate.
+type Engine int
+
+const (
+	// NFA simulates the Thompson NFA.
+	NFA Engine = iota
+	// DFA runs a minimal deterministic automaton built by subset construction
+	// when the expression is compiled.
+	DFA
+	// LazyDFA builds the states of a DFA as the input reaches them, caching
+	// a bounded number, and simulates the NFA if the cache thrashes.
+	LazyDFA
+)
+
+// A Regexp is a compiled regular expression. It is safe for concurrent use.
+type Regexp struct {
+	expr  string
+	names []string
+	nfa   *nfa.NFA
+	dfa   *dfa.DFA
+	lazy  *dfa.Lazy
+	// asserts is set if the NFA has assertions, which the DFAs cannot check
+	// on part of the input.
+	asserts bool
+}
+
+// Compile parses a regular expression and returns a Regexp that can be used
+// to match against text.
+func Compile(expr string) (*Regexp, error) {
+	return CompileEngine(expr, NFA)
+}
+
+// CompileEngine is like Compile but matches with the given engine.
+func CompileEngine(expr string, engine Engine) (*Regexp, error) {
+	return compile(expr, compiler.Options{}, engine)
+}
+
+// CompilePOSIX is like Compile but restricts the regular expression to POSIX
+// ERE syntax, and changes the match semantics to leftmost-longest: among the
+// matches that begin leftmost, the longest is chosen, as POSIX specifies and
+// as egrep does.
+func CompilePOSIX(expr string) (*Regexp, error) {
+	return compile(expr, compiler.Options{Syntax: compiler.ERE}, NFA)
+}
+
+// compile parses the expression with the options and returns its Regexp,
+// which matches with the engine.
+func compile(expr string, opts compiler.Options, engine Engine) (*Regexp, error) {
+	prog, err := compiler.Parse(expr, opts)
+	if err != nil {
+		return nil, err
+	}
+	automaton, err := prog.NFA()
+	if err != nil {
+		return nil, err
+	}
+	re := &Regexp{expr: expr, names: prog.SubexpNames(), nfa: automaton, asserts: automaton.HasAssertions()}
+	switch engine {
+	case DFA:
+		deterministic, err := dfa.New(automaton, dfa.Config{Unanchored: true})
+		if errors.Is(err, dfa.ErrAssertions) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		re.dfa = deterministic.Minimize()
+	case LazyDFA:
+		re.lazy = dfa.NewLazy(automaton, 0)
+	}
+	return re, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed.
+func MustCompile(expr string) *Regexp {
+	re, err := Compile(expr)
+	if err != nil {
+		panic(`engine: Compile(` + expr + `): ` + err.Error())
+	}
+	return re
+}
+
+// MustCompilePOSIX is like CompilePOSIX but panics if the expression cannot
+// be parsed.
+func MustCompilePOSIX(expr string) *Regexp {
+	re, err := CompilePOSIX(expr)
+	if err != nil {
+		panic(`engine: CompilePOSIX(` + expr + `): ` + err.Error())
+	}
+	return re
+}
+
+// String returns the source text used to compile the regular expression.
+func (re *Regexp) String() string {
+	return re.expr
+}
+
+// MatchString reports whether the string s contains any match of the regular
+// expression.
+func (re *Regexp) MatchString(s string) bool {
+	if re.dfa != nil {
+		return re.dfa.AcceptsPrefix(s)
+	}
+	if re.lazy != nil {
+		return re.lazy.Match(s)
+	}
+	return re.nfa.Match(s)
+}
+
+// find returns the leftmost match in s, as the NFA's Find does, unless a DFA
+// shows that s holds none. Only the first search of an input is checked so:
+// checking the rest of the input again before each later match would scan it
+// twice, and in measurements made FindAll slower wherever there were matches.
+func (re *Regexp) find(s string) []int {
+	switch {
+	case re.asserts:
+	case re.dfa != nil && !re.dfa.AcceptsPrefix(s):
+		return nil
+	case re.lazy != nil && !re.lazy.Match(s):
+		return nil
+	}
+	return re.nfa.Find(s, 0)
+}
+
+// NumSubexp returns the number of parenthesized subexpressions in the regular
+// expression.
+func (re *Regexp) NumSubexp() int {
+	return re.nfa.Groups
+}
+
+// SubexpNames returns the names of the parenthesized subexpressions in the
+// regular expression. The name for the first subexpression is names[1], so
+// that if m is a match slice, the name for m[i] is SubexpNames()[i]. Since
+// the expression as a whole cannot be named, names[0] is always the empty
+// string, as are the names of unnamed subexpressions. The slice should not
+// be modified.
+func (re *Regexp) SubexpNames() []string {
+	return re.names
+}
+
+// SubexpIndex returns the index of the first subexpression with the given
+// name, or -1 if there is no subexpression with that name.
+func (re *Regexp) SubexpIndex(name string) int {
+	if name != "" {
+		for i, s := range re.names {
+			if name == s {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// FindStringIndex returns a two-element slice of integers defining the
+// location of the leftmost match in s. A return value of nil indicates no
+// match.
+func (re *Regexp) FindStringIndex(s string) []int {
+	loc := re.find(s)
+	if loc == nil {
+		return nil
+	}
+	return loc[:2]
+}
+
+// FindString returns the text of the leftmost match in s. If there is no
+// match, the return value is the empty string, as it is for an empty match.
+func (re *Regexp) FindString(s string) string {
+	loc := re.FindStringIndex(s)
+	if loc == nil {
+		return ""
+	}
+	return s[loc[0]:loc[1]]
+}
+
+// FindStringSubmatchIndex returns the location of the leftmost match in s
+// followed by those of its submatches, as pairs of indices. A submatch that
+// took no part in the match has indices -1. A return value of nil indicates
+// no match.
+func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+	return re.find(s)
+}
+
+// FindStringSubmatch returns the text of the leftmost match in s followed by
+// the text of its submatches, empty for those that took no part in the match.
+// A return value of nil indicates no match.
+func (re *Regexp) FindStringSubmatch(s string) []string {
+	return submatches(s, re.FindStringSubmatchIndex(s))
+}
+
+// FindAllStringIndex returns the locations of successive non-overlapping
+// matches in s. If n >= 0 at most n matches are returned. An empty match
+// abutting a preceding match is ignored. A return value of nil indicates no
+// match.
+func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
+	locs := re.FindAllStringSubmatchIndex(s, n)
+	for i, loc := range locs {
+		locs[i] = loc[:2]
+	}
+	return locs
+}
+
+// FindAllStringSubmatchIndex is like FindAllStringIndex, but returns the
+// locations of the submatches of each match too, as FindStringSubmatchIndex
+// does.
+func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	if n < 0 {
+		n = len(s) + 1
+	}
+	var locs [][]int
+	for pos, prevEnd := 0, -1; len(locs) < n && pos <= len(s); {
+		var loc []int
+		if pos == 0 {
+			loc = re.find(s)
+		} else {
+			loc = re.nfa.Find(s, pos)
+		}
+		if loc == nil {
+			break
+		}
+		accept := true
+		if loc[1] == pos {
+			// an empty match right after a previous match is ignored
+			if loc[0] == prevEnd {
+				accept = false
+			}
+			if _, width := utf8.DecodeRuneInString(s[pos:]); width > 0 {
+				pos += width
+			} else {
+				pos++
+			}
+		} else {
+			pos = loc[1]
+		}
+		prevEnd = loc[1]
+		if accept {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// FindAllStringSubmatch returns the text of successive non-overlapping
+// matches in s and their submatches, as for FindAllStringSubmatchIndex.
+func (re *Regexp) FindAllStringSubmatch(s string, n int) [][]string {
+	locs := re.FindAllStringSubmatchIndex(s, n)
+	if locs == nil {
+		return nil
+	}
+	matches := make([][]string, len(locs))
+	for i, loc := range locs {
+		matches[i] = submatches(s, loc)
+	}
+	return matches
+}
+
+// submatches returns the text of s at each pair of indices of loc.
+func submatches(s string, loc []int) []string {
+	if loc == nil {
+		return nil
+	}
+	texts := make([]string, len(loc)/2)
+	for i := range texts {
+		if loc[2*i] >= 0 {
+			texts[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return texts
+}
+
+// FindAllString returns the text of successive non-overlapping matches in s,
+// as for FindAllStringIndex.
+func (re *Regexp) FindAllString(s string, n int) []string {
+	locs := re.FindAllStringIndex(s, n)
+	if locs == nil {
+		return nil
+	}
+	matches := make([]string, len(locs))
+	for i, loc := range locs {
+		matches[i] = s[loc[0]:loc[1]]
+	}
+	return matches
+}
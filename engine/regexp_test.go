@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFindAllStringIndex(t *testing.T) {
+	cases := map[string][]string{
+		"a(b|c)*d":                 {"abccd", "xadyabdz", "ad ad", "abc"},
+		"a*a":                      {"aaa", "baab", ""},
+		"(a|ab)c":                  {"abc", "ac", "xabcac"},
+		"(a|ab)(c|bcd)":            {"abcd"},
+		"a*":                       {"", "b", "aab", "baaa"},
+		"(a*)*b":                   {"aab", "b", "ba"},
+		"(ab)+|c":                  {"ababcab", "a"},
+		"andrew|jackson":           {"andrew jackson", "andjackandrew"},
+		"[a-c]+x":                  {"abxcax", "dx"},
+		"[^ab]+":                   {"xabyé"},
+		"[0-9a-fA-F]+":             {"0x1F 0xg"},
+		`\(a\|b\)\*`:               {"x(a|b)*y"},
+		`[\x{e9}\t]+`:              {"caf\u00e9\t!"},
+		"colou?r":                  {"color colour colouur"},
+		`a.c|.\x{e9}`:              {"abc a\nc xé", "\né"},
+		"(a?)*b":                   {"aab", "b"},
+		"a{2,3}":                   {"a aa aaaa aaaaaaa"},
+		"(ab){2}|b{2,}":            {"abababbbb"},
+		"(a|b){0,2}c":              {"abc bbbc c"},
+		"^a+":                      {"aab aa", "baa"},
+		"b*$":                      {"abb", "ba", ""},
+		"^$":                       {"", "a"},
+		"$|a":                      {"aa"},
+		"(^|x)y($|z)":              {"yxyz", "xy y"},
+		"café|naïve":               {"a café, naïve ça", "cafe"},
+		"日本語?|[^本]":                {"日本 日本語語"},
+		"(|a)*":                    {"aa"},
+		"(|a)+b":                   {"aab"},
+		"":                         {"", "ab"},
+		"()":                       {"ab"},
+		"(|a)":                     {"ab", ""},
+		"a||b":                     {"abc"},
+		"x(|a|)(b|)":               {"xab xb x"},
+		"((a)|b)+":                 {"abba"},
+		"(a*){2,}b":                {"aab", "b"},
+		"(a(b)?)+":                 {"aba"},
+		"(?:ab)+(c)|(?:x(?P<y>y))": {"ababc xy abx"},
+		`(?P<year>[0-9]{4})\-(?P<month>[0-9]{2})`: {"2024-01 1999-12"},
+		`\<(.*?)\>`:              {"<a><bc> <>"},
+		"a+?|b*?c":               {"aab bbc c"},
+		"(a|b)??b":               {"ab b"},
+		"a{2,4}?(a*)":            {"aaaaa"},
+		"(a*?)*b|(a+?)+c":        {"aab aac b"},
+		"(a??)(a{0,2}?)x":        {"aax ax"},
+		`\d+(\.\d+)?`:            {"pi is 3.14, e 2.7.1 ٣"},
+		`[\w\-]+|\S\s`:           {"a_b-9 é! x"},
+		`[^\d\s]+|\D\W`:          {"ab1 c\td2e", "é."},
+		`\p{Greek}+|[\p{Lu}\d]+`: {"Καλημέρα κόσμε ABC12 xyz"},
+		`\P{L}+|\pN\p{^L}`:       {"ab, 12; ½!"},
+		`(\p{Han}+)(\p{Latin}*)`: {"日本語abc 漢字 x"},
+		`(?i)straße|k+`:          {"STRASSE STRAẞE Straße KkK"},
+		`(?i)[^k\d]+`:            {"aKb1K\u212ac"},
+		`a(?i)b|c`:               {"aB C c AB"},
+		`x(?i:A)a`:               {"xaa xAa xAA"},
+		`(?m)^\w+$`:              {"ab\ncd\n\nef g"},
+		`(?ms)^a.b$`:             {"a\nb\na b"},
+		`(?m)^|$`:                {"a\nb\n"},
+		`(?i)\W+|\p{Lu}+`:        {"ſK! abc ÉÈ"},
+		`(?i)(é)(?-i:É)`:         {"éÉ ÉÉ éé"},
+	}
+	for expr, inputs := range cases {
+		std := regexp.MustCompile(expr)
+		for _, engine := range []Engine{NFA, DFA, LazyDFA} {
+			re, err := CompileEngine(expr, engine)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if re.NumSubexp() != std.NumSubexp() {
+				t.Fatalf("%q: expected %d subexpressions got %d", expr, std.NumSubexp(), re.NumSubexp())
+			}
+			if !reflect.DeepEqual(re.SubexpNames(), std.SubexpNames()) {
+				t.Fatalf("%q: expected names %q got %q", expr, std.SubexpNames(), re.SubexpNames())
+			}
+			for _, input := range inputs {
+				if got, want := re.FindAllStringIndex(input, -1), std.FindAllStringIndex(input, -1); !reflect.DeepEqual(got, want) {
+					t.Fatalf("%q on %q: expected %v got %v", expr, input, want, got)
+				}
+				if got, want := re.MatchString(input), std.MatchString(input); got != want {
+					t.Fatalf("%q on %q: expected match %v got %v", expr, input, want, got)
+				}
+				if got, want := re.FindString(input), std.FindString(input); got != want {
+					t.Fatalf("%q on %q: expected %q got %q", expr, input, want, got)
+				}
+				if got, want := re.FindAllStringSubmatchIndex(input, -1), std.FindAllStringSubmatchIndex(input, -1); !reflect.DeepEqual(got, want) {
+					t.Fatalf("%q on %q: expected submatches %v got %v", expr, input, want, got)
+				}
+			}
+		}
+	}
+}
+
+// TestOperators checks the operators '&' and '~', which the standard library
+// lacks, against the matches expected of them.
+func TestOperators(t *testing.T) {
+	cases := map[string]map[string][]string{
+		"[a-z]+&~(if|for|func)": {"if x for forty func funcs": {"i", "f", "x", "fo", "r", "forty", "fun", "c", "funcs"}},
+		"a+&(aa)*":              {"aaaaa a aa": {"aaaa", "aa"}},
+		"~(.*a.*)":              {"xay": {"x", "y"}, "xay\nb": {"xay\nb"}},
+		"(x)~(.*x.*)":           {"xyxzx": {"xy", "xz", "x"}},
+		`[0-9]+&~(0.*)|0`:       {"0120 7": {"0", "120", "7"}},
+		"~(.*a.*)&~(.*b.*)":     {"xaby": {"x", "", "y"}},
+	}
+	for expr, inputs := range cases {
+		for _, engine := range []Engine{NFA, DFA, LazyDFA} {
+			re, err := CompileEngine(expr, engine)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for input, want := range inputs {
+				if got := re.FindAllString(input, -1); !reflect.DeepEqual(got, want) {
+					t.Fatalf("%q on %q: expected %q got %q", expr, input, want, got)
+				}
+				if got := re.MatchString(input); !got {
+					t.Fatalf("%q on %q: expected a match", expr, input)
+				}
+			}
+		}
+	}
+	re := MustCompile("(a)&(a)|(b)")
+	if re.NumSubexp() != 1 {
+		t.Fatalf("expected the groups of operands not to count, got %d subexpressions", re.NumSubexp())
+	}
+	if m := re.FindStringSubmatch("b"); !reflect.DeepEqual(m, []string{"b", "b"}) {
+		t.Fatalf("expected submatches %q got %q", []string{"b", "b"}, m)
+	}
+}
+
+// TestCompilePOSIX checks ERE and leftmost-longest matching against the
+// standard library.
+func TestCompilePOSIX(t *testing.T) {
+	cases := map[string][]string{
+		"(a|ab)(c|bcd)(d*)":         {"abcd", "xabcdd"},
+		"(wee|week)(knights|night)": {"weeknights"},
+		"a*|b":                      {"aab b", ""},
+		"(a|ab)c|abcd":              {"abcd abc"},
+		"x*y+|xy*z":                 {"xyyz xxyy"},
+		"(a+|b+)*c?":                {"abbac ba"},
+		"a{2,3}|a+b":                {"aaaab aaaa"},
+		"[[:alpha:]]+[[:digit:]]*":  {"abc123 x9 7"},
+		"[]a-]+|[^[:space:]]":       {"a]-b c"},
+		"(.)(.*)":                   {"héllo"},
+		`^(a|ab)(b*)$`:              {"abb", "ab"},
+		`\(a\|b\)`:                  {"x(a|b)y"},
+		"(|a)+b":                    {"aab"},
+	}
+	for expr, inputs := range cases {
+		std := regexp.MustCompilePOSIX(expr)
+		re := MustCompilePOSIX(expr)
+		for _, input := range inputs {
+			if got, want := re.FindAllStringIndex(input, -1), std.FindAllStringIndex(input, -1); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%q on %q: expected %v got %v", expr, input, want, got)
+			}
+			if got, want := re.FindAllStringSubmatchIndex(input, -1), std.FindAllStringSubmatchIndex(input, -1); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%q on %q: expected submatches %v got %v", expr, input, want, got)
+			}
+		}
+	}
+	if _, err := CompilePOSIX(`(a)\1`); err == nil {
+		t.Fatal("expected back-references to be rejected")
+	}
+}
+
+// TestLazyFind checks that the Find methods consult the lazy DFA before
+// simulating the NFA.
+func TestLazyFind(t *testing.T) {
+	re, err := CompileEngine("a+b", LazyDFA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := re.FindAllStringIndex("xab aab", -1), [][]int{{1, 3}, {4, 7}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+	if loc := re.FindStringIndex("aaaa"); loc != nil {
+		t.Fatalf("expected no match got %v", loc)
+	}
+	if stats := re.lazy.Stats(); stats.States == 0 || stats.Fallbacks != 0 {
+		t.Fatalf("expected the lazy DFA to build states without falling back, got %+v", stats)
+	}
+}
+
+func TestSubexpIndex(t *testing.T) {
+	re := MustCompile(`(?P<year>[0-9]{4})\-([0-9]{2})\-(?P<day>[0-9]{2})`)
+	for name, want := range map[string]int{"year": 1, "day": 3, "month": -1, "": -1} {
+		if got := re.SubexpIndex(name); got != want {
+			t.Fatalf("%q: expected %d got %d", name, want, got)
+		}
+	}
+	if m := re.FindStringSubmatch("on 2024-01-31"); m[re.SubexpIndex("day")] != "31" {
+		t.Fatalf("expected day 31 got %q", m)
+	}
+}
+
+// BenchmarkFindAll compares the engines on inputs with many matches, one match
+// at the end and none, where the DFAs let the NFA skip the input.
+func BenchmarkFindAll(b *testing.B) {
+	inputs := []struct{ name, s string }{
+		{"dense", strings.Repeat("xab aab ", 2000)},
+		{"last", strings.Repeat("the quick brown fox ", 2000) + "aab"},
+		{"none", strings.Repeat("the quick brown fox ", 2000)},
+	}
+	engines := []struct {
+		name   string
+		engine Engine
+	}{{"nfa", NFA}, {"dfa", DFA}, {"lazy", LazyDFA}}
+	for _, input := range inputs {
+		for _, e := range engines {
+			re, err := CompileEngine("[a-z]*a+b", e.engine)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(input.name+"/"+e.name, func(b *testing.B) {
+				b.SetBytes(int64(len(input.s)))
+				for i := 0; i < b.N; i++ {
+					re.FindAllStringIndex(input.s, -1)
+				}
+			})
+		}
+	}
+}
@@ -1,5 +1,10 @@
 module thompson-regex
 
-go 1.16
+go 1.18
 
 require github.com/spf13/cobra v1.5.0
+
+require (
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+)
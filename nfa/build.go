@@ -0,0 +1,197 @@
+package nfa
+
+// A Frag is a partially built automaton. It is entered through start and
+// left through end, whose Out has not yet been patched.
+type Frag struct {
+	start, end int
+}
+
+// A Builder accumulates the states of an automaton under construction. Each
+// of its methods returns the fragment for an expression given the fragments
+// for its subexpressions, which must not be used again.
+type Builder struct {
+	states []State
+	groups int
+}
+
+func (b *Builder) add(s State) int {
+	b.states = append(b.states, s)
+	return len(b.states) - 1
+}
+
+func (b *Builder) patch(end, to int) {
+	b.states[end].Out = to
+}
+
+func (b *Builder) epsilon() int {
+	return b.add(State{Kind: Epsilon, Out: -1, Out1: -1})
+}
+
+// Empty returns a fragment matching the empty string.
+func (b *Builder) Empty() Frag {
+	e := b.epsilon()
+	return Frag{e, e}
+}
+
+// Fail returns a fragment matching nothing, a class of no runes.
+func (b *Builder) Fail() Frag {
+	return b.Class(nil)
+}
+
+// Rune returns a fragment matching the rune c.
+func (b *Builder) Rune(c rune) Frag {
+	s := b.add(State{Kind: Rune, Rune: c, Out: -1, Out1: -1})
+	return Frag{s, s}
+}
+
+// Class returns a fragment matching any rune in the ranges, which are sorted
+// pairs of inclusive bounds.
+func (b *Builder) Class(ranges []rune) Frag {
+	s := b.add(State{Kind: Class, Ranges: ranges, Out: -1, Out1: -1})
+	return Frag{s, s}
+}
+
+// Assert returns a fragment matching the empty string where the anchors hold.
+func (b *Builder) Assert(a Anchor) Frag {
+	s := b.add(State{Kind: Assert, Anchor: a, Out: -1, Out1: -1})
+	return Frag{s, s}
+}
+
+// Concat returns a fragment matching x followed by y.
+func (b *Builder) Concat(x, y Frag) Frag {
+	b.patch(x.end, y.start)
+	return Frag{x.start, y.end}
+}
+
+// Or returns a fragment matching x or y, preferring x.
+func (b *Builder) Or(x, y Frag) Frag {
+	s := b.add(State{Kind: Split, Out: x.start, Out1: y.start})
+	e := b.epsilon()
+	b.patch(x.end, e)
+	b.patch(y.end, e)
+	return Frag{s, e}
+}
+
+// Star returns a fragment matching zero or more occurrences of x, preferring
+// as many as possible, or if lazy as few. If x can match the empty string,
+// the fragment is built as (x+)?, as in Go's regexp, so that an empty
+// occurrence is preferred to none.
+func (b *Builder) Star(x Frag, lazy bool) Frag {
+	if b.nullable(x) {
+		return b.Quest(b.Plus(x, lazy), lazy)
+	}
+	e := b.epsilon()
+	s := b.split(x.start, e, lazy)
+	b.patch(x.end, s)
+	return Frag{s, e}
+}
+
+// Plus returns a fragment matching one or more occurrences of x, preferring
+// as many as possible, or if lazy as few.
+func (b *Builder) Plus(x Frag, lazy bool) Frag {
+	e := b.epsilon()
+	s := b.split(x.start, e, lazy)
+	b.patch(x.end, s)
+	return Frag{x.start, e}
+}
+
+// Quest returns a fragment matching zero or one occurrences of x, preferring
+// one, or if lazy zero.
+func (b *Builder) Quest(x Frag, lazy bool) Frag {
+	e := b.epsilon()
+	s := b.split(x.start, e, lazy)
+	b.patch(x.end, e)
+	return Frag{s, e}
+}
+
+// split adds a state leading to more, for another occurrence, and to done,
+// preferring more unless lazy.
+func (b *Builder) split(more, done int, lazy bool) int {
+	if lazy {
+		return b.add(State{Kind: Split, Out: done, Out1: more})
+	}
+	return b.add(State{Kind: Split, Out: more, Out1: done})
+}
+
+// A Transition of an automaton given to DFA moves to the state To on any
+// rune in Ranges, which are sorted pairs of inclusive bounds.
+type Transition struct {
+	Ranges []rune
+	To     int
+}
+
+// DFA returns a fragment matching the strings that a deterministic automaton
+// accepts from the state start, where trans[s] lists the transitions of state
+// s and accepting[s] reports whether it accepts. Each state tries its
+// transitions before leaving the fragment, so that it prefers longer matches,
+// as a star does.
+func (b *Builder) DFA(start int, trans [][]Transition, accepting []bool) Frag {
+	exit := b.epsilon()
+	// entry[s] is the state for s, which is patched once all of them exist
+	entry := make([]int, len(trans))
+	for s := range entry {
+		entry[s] = b.epsilon()
+	}
+	for s, ts := range trans {
+		var ways []int
+		for _, t := range ts {
+			ways = append(ways, b.add(State{Kind: Class, Ranges: t.Ranges, Out: entry[t.To], Out1: -1}))
+		}
+		if accepting[s] {
+			ways = append(ways, exit)
+		}
+		if len(ways) == 0 {
+			ways = append(ways, b.add(State{Kind: Class, Out: exit, Out1: -1}))
+		}
+		next := ways[len(ways)-1]
+		for i := len(ways) - 2; i >= 0; i-- {
+			next = b.add(State{Kind: Split, Out: ways[i], Out1: next})
+		}
+		b.patch(entry[s], next)
+	}
+	return Frag{entry[start], exit}
+}
+
+// Capture returns a fragment matching x that records the bounds of the match
+// as capture group n.
+func (b *Builder) Capture(x Frag, n int) Frag {
+	s := b.add(State{Kind: Save, Slot: 2 * n, Out: x.start, Out1: -1})
+	e := b.add(State{Kind: Save, Slot: 2*n + 1, Out: -1, Out1: -1})
+	b.patch(x.end, e)
+	if n > b.groups {
+		b.groups = n
+	}
+	return Frag{s, e}
+}
+
+// nullable reports whether x can match the empty string.
+func (b *Builder) nullable(x Frag) bool {
+	seen := map[int]bool{}
+	var visit func(int) bool
+	visit = func(q int) bool {
+		// only the Out of x.end is left unpatched
+		if q < 0 {
+			return true
+		}
+		if seen[q] {
+			return false
+		}
+		seen[q] = true
+		switch s := b.states[q]; s.Kind {
+		case Epsilon, Assert, Save:
+			return visit(s.Out)
+		case Split:
+			return visit(s.Out) || visit(s.Out1)
+		}
+		return false
+	}
+	return visit(x.start)
+}
+
+// Finish terminates the fragment in the accepting state and returns the
+// automaton. The Builder must not be used afterwards.
+func (b *Builder) Finish(f Frag) *NFA {
+	accept := b.add(State{Kind: Match, Out: -1, Out1: -1})
+	b.patch(f.end, accept)
+	return &NFA{b.states, f.start, accept, b.groups, false}
+}
@@ -0,0 +1,84 @@
+package nfa
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	cases := map[string]struct {
+		build func(b *Builder) Frag
+		dump  string
+	}{
+		"ab": {func(b *Builder) Frag { return b.Concat(b.Rune('a'), b.Rune('b')) }, `start 0, accept 2
+0: 'a' → 1
+1: 'b' → 2
+2: match
+`},
+		"a|b": {func(b *Builder) Frag { return b.Or(b.Rune('a'), b.Rune('b')) }, `start 2, accept 4
+0: 'a' → 3
+1: 'b' → 3
+2: ε → 0, 1
+3: ε → 4
+4: match
+`},
+		"a*": {func(b *Builder) Frag { return b.Star(b.Rune('a'), false) }, `start 2, accept 3
+0: 'a' → 2
+1: ε → 3
+2: ε → 0, 1
+3: match
+`},
+		"a+": {func(b *Builder) Frag { return b.Plus(b.Rune('a'), false) }, `start 0, accept 3
+0: 'a' → 2
+1: ε → 3
+2: ε → 0, 1
+3: match
+`},
+		"a*?": {func(b *Builder) Frag { return b.Star(b.Rune('a'), true) }, `start 2, accept 3
+0: 'a' → 2
+1: ε → 3
+2: ε → 1, 0
+3: match
+`},
+		"ε": {func(b *Builder) Frag { return b.Empty() }, `start 0, accept 1
+0: ε → 1
+1: match
+`},
+		"∅": {func(b *Builder) Frag { return b.Fail() }, `start 0, accept 1
+0: [] → 1
+1: match
+`},
+		"ε*": {func(b *Builder) Frag { return b.Star(b.Empty(), false) }, `start 4, accept 5
+0: ε → 2
+1: ε → 3
+2: ε → 0, 1
+3: ε → 5
+4: ε → 0, 3
+5: match
+`},
+		"a+ as a DFA": {func(b *Builder) Frag {
+			a := []Transition{{Ranges: []rune{'a', 'a'}, To: 2}}
+			return b.DFA(1, [][]Transition{nil, a, a}, []bool{false, false, true})
+		}, `start 2, accept 8
+0: ε → 8
+1: ε → 4
+2: ε → 5
+3: ε → 7
+4: [] → 0
+5: ['a'] → 3
+6: ['a'] → 3
+7: ε → 6, 0
+8: match
+`},
+		"(a)": {func(b *Builder) Frag { return b.Capture(b.Rune('a'), 1) }, `start 1, accept 3
+groups 1
+0: 'a' → 2
+1: save 2 → 0
+2: save 3 → 3
+3: match
+`},
+	}
+	for expr, c := range cases {
+		var b Builder
+		if out := b.Finish(c.build(&b)).String(); out != c.dump {
+			t.Fatalf("%q: expected\n%s\ngot\n%s", expr, c.dump, out)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package nfa builds nondeterministic finite automata out of regular
+// expressions using Thompson's construction.
+package nfa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Kind identifies what a State does.
+type Kind uint8
+
+const (
+	// An Epsilon state moves to Out without consuming input.
+	Epsilon Kind = iota
+	// A Split state moves to both Out and Out1 without consuming input.
+	Split
+	// A Rune state consumes the rune Rune and moves to Out.
+	Rune
+	// A Class state consumes any rune in Ranges and moves to Out.
+	Class
+	// An Assert state moves to Out without consuming input if every anchor
+	// in Anchor holds at the current position.
+	Assert
+	// A Save state records the current position in capture slot Slot and
+	// moves to Out without consuming input.
+	Save
+	// A Match state is the accepting state.
+	Match
+)
+
+// An Anchor is a set of conditions on the position in the input.
+type Anchor uint8
+
+const (
+	// BeginText holds at the beginning of the input.
+	BeginText Anchor = 1 << iota
+	// EndText holds at the end of the input.
+	EndText
+	// BeginLine holds at the beginning of the input and after each newline.
+	BeginLine
+	// EndLine holds at the end of the input and before each newline.
+	EndLine
+)
+
+// anchorsAt returns the anchors that hold at byte offset i of the input.
+func anchorsAt(input string, i int) Anchor {
+	var a Anchor
+	if i == 0 {
+		a |= BeginText | BeginLine
+	} else if input[i-1] == '\n' {
+		a |= BeginLine
+	}
+	if i == len(input) {
+		a |= EndText | EndLine
+	} else if input[i] == '\n' {
+		a |= EndLine
+	}
+	return a
+}
+
+// A State is a single node of the automaton. Transitions are given as indices
+// into the States of the NFA, with -1 denoting no transition.
+type State struct {
+	Kind Kind
+	Rune rune
+	// Ranges holds sorted pairs of inclusive bounds.
+	Ranges    []rune
+	Anchor    Anchor
+	Slot      int
+	Out, Out1 int
+}
+
+// Consumes reports whether the state consumes the rune r.
+func (s *State) Consumes(r rune) bool {
+	switch s.Kind {
+	case Rune:
+		return s.Rune == r
+	case Class:
+		for i := 0; i < len(s.Ranges); i += 2 {
+			if r < s.Ranges[i] {
+				return false
+			}
+			if r <= s.Ranges[i+1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// An NFA is an automaton with a single start state and a single accepting
+// state. Its capture groups are numbered from 1 to Groups, group n being
+// bounded by the Save states for slots 2n and 2n+1. If Longest is set, Find
+// prefers the longest of the matches that begin leftmost, as POSIX does.
+type NFA struct {
+	States        []State
+	Start, Accept int
+	Groups        int
+	Longest       bool
+}
+
+// HasAssertions reports whether the automaton has any Assert states.
+func (n *NFA) HasAssertions() bool {
+	for _, s := range n.States {
+		if s.Kind == Assert {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NFA) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "start %d, accept %d\n", n.Start, n.Accept)
+	if n.Groups > 0 {
+		fmt.Fprintf(&b, "groups %d\n", n.Groups)
+	}
+	if n.Longest {
+		b.WriteString("longest\n")
+	}
+	for i, s := range n.States {
+		switch s.Kind {
+		case Epsilon:
+			fmt.Fprintf(&b, "%d: ε → %d\n", i, s.Out)
+		case Split:
+			fmt.Fprintf(&b, "%d: ε → %d, %d\n", i, s.Out, s.Out1)
+		case Rune:
+			fmt.Fprintf(&b, "%d: %q → %d\n", i, s.Rune, s.Out)
+		case Class:
+			b.WriteString(strconv.Itoa(i) + ": [")
+			for j := 0; j < len(s.Ranges); j += 2 {
+				if s.Ranges[j] == s.Ranges[j+1] {
+					fmt.Fprintf(&b, "%q", s.Ranges[j])
+				} else {
+					fmt.Fprintf(&b, "%q-%q", s.Ranges[j], s.Ranges[j+1])
+				}
+			}
+			fmt.Fprintf(&b, "] → %d\n", s.Out)
+		case Assert:
+			fmt.Fprintf(&b, "%d: %s → %d\n", i, s.Anchor, s.Out)
+		case Save:
+			fmt.Fprintf(&b, "%d: save %d → %d\n", i, s.Slot, s.Out)
+		case Match:
+			fmt.Fprintf(&b, "%d: match\n", i)
+		}
+	}
+	return b.String()
+}
+
+func (a Anchor) String() string {
+	var b strings.Builder
+	if a&BeginText != 0 {
+		b.WriteString("^")
+	}
+	if a&EndText != 0 {
+		b.WriteString("$")
+	}
+	if a&BeginLine != 0 {
+		b.WriteString("(?m:^)")
+	}
+	if a&EndLine != 0 {
+		b.WriteString("(?m:$)")
+	}
+	return b.String()
+}
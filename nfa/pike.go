@@ -0,0 +1,137 @@
+package nfa
+
+import "unicode/utf8"
+
+// A thread is a position in the automaton together with the capture slots
+// of its match so far: the offset at which it began, a placeholder for its
+// end, and the bounds of each capture group, -1 where unset. Threads share
+// their slots, so Save states copy them before writing.
+type thread struct {
+	state int
+	caps  []int
+}
+
+// A queue is an ordered set of threads, at most one per state. The order is
+// the priority of the threads: earlier threads correspond to matches that are
+// preferred by the leftmost-first rule.
+type queue struct {
+	threads []thread
+	// visited lists the states marked in onqueue, including those only
+	// passed through by ε-transitions.
+	visited []int
+	onqueue []bool
+}
+
+func newQueue(n int) *queue {
+	return &queue{make([]thread, 0, n), make([]int, 0, n), make([]bool, n)}
+}
+
+func (q *queue) clear() {
+	for _, state := range q.visited {
+		q.onqueue[state] = false
+	}
+	q.threads, q.visited = q.threads[:0], q.visited[:0]
+}
+
+// add places the thread on the queue, following ε-transitions in priority
+// order so that only states that consume input or accept are kept. The
+// anchors are those holding at the position the queue is for.
+func (n *NFA) add(q *queue, state int, caps []int, i int, anchors Anchor) {
+	if state < 0 || q.onqueue[state] {
+		return
+	}
+	q.onqueue[state] = true
+	q.visited = append(q.visited, state)
+	s := &n.States[state]
+	switch s.Kind {
+	case Epsilon:
+		n.add(q, s.Out, caps, i, anchors)
+	case Split:
+		n.add(q, s.Out, caps, i, anchors)
+		n.add(q, s.Out1, caps, i, anchors)
+	case Assert:
+		if s.Anchor&anchors == s.Anchor {
+			n.add(q, s.Out, caps, i, anchors)
+		}
+	case Save:
+		saved := append([]int(nil), caps...)
+		saved[s.Slot] = i
+		n.add(q, s.Out, saved, i, anchors)
+	default:
+		q.threads = append(q.threads, thread{state, caps})
+	}
+}
+
+// search runs every thread of the automaton over the input in lockstep,
+// starting at byte offset pos, and returns the capture slots of the leftmost
+// match, or nil if there is none. The simulation takes time proportional to
+// the product of the lengths of the input and the automaton. If earliest is
+// set, search stops at the first match found rather than extending it. If
+// the automaton is Longest, a match is only replaced by a longer one with the
+// same start, so that the threads of lower priority run on.
+func (n *NFA) search(input string, pos int, earliest bool) []int {
+	clist, nlist := newQueue(len(n.States)), newQueue(len(n.States))
+	var matched []int
+	for i := pos; ; {
+		if matched == nil {
+			caps := make([]int, 2*n.Groups+2)
+			for j := range caps {
+				caps[j] = -1
+			}
+			caps[0] = i
+			n.add(clist, n.Start, caps, i, anchorsAt(input, i))
+		}
+		if len(clist.threads) == 0 && matched != nil {
+			break
+		}
+		r, width := utf8.DecodeRuneInString(input[i:])
+		next := anchorsAt(input, i+width)
+		for _, t := range clist.threads {
+			s := &n.States[t.state]
+			if n.Longest && matched != nil && t.caps[0] > matched[0] {
+				// a thread that began later can't lead to a leftmost match
+				continue
+			}
+			if s.Kind == Match {
+				if n.Longest && matched != nil && matched[1] == i {
+					continue
+				}
+				matched = append([]int(nil), t.caps...)
+				matched[1] = i
+				if earliest {
+					return matched
+				}
+				if n.Longest {
+					continue
+				}
+				// the remaining threads have lower priority
+				break
+			}
+			if width > 0 && s.Consumes(r) {
+				n.add(nlist, s.Out, t.caps, i+width, next)
+			}
+		}
+		if width == 0 {
+			break
+		}
+		i += width
+		clist, nlist = nlist, clist
+		nlist.clear()
+	}
+	return matched
+}
+
+// Match reports whether the input contains a match of the automaton.
+func (n *NFA) Match(input string) bool {
+	return n.search(input, 0, true) != nil
+}
+
+// Find returns the byte offsets of the leftmost match in the input that
+// begins at or after pos, followed by those of each capture group, or nil if
+// there is no match. A group that took no part in the match has offsets -1.
+// Among the matches that begin leftmost, the one preferred by the automaton's
+// transition order is chosen, as in Perl, or if the automaton is Longest the
+// longest of them.
+func (n *NFA) Find(input string, pos int) []int {
+	return n.search(input, pos, false)
+}